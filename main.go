@@ -2,16 +2,37 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"github.com/1and1internet/supervisorgo/managed_procs"
 	"os"
 	"log"
+	"strings"
 )
 
+type configFiles []string
+
+func (files *configFiles) String() string {
+	return strings.Join(*files, ",")
+}
+
+func (files *configFiles) Set(value string) error {
+	*files = append(*files, value)
+	return nil
+}
+
 func main() {
-	var supervisorConf = flag.String(
+	var supervisorConfs configFiles
+	flag.Var(
+		&supervisorConfs,
 		"c",
-		"/etc/supervisor/supervisord.conf",
-		"The master config file. Default is /etc/supervisor/supervisord.conf")
+		"The master config file. Default is /etc/supervisor/supervisord.conf. "+
+			"Can be given more than once, later files are loaded after the first one")
+
+	var override = flag.Bool(
+		"override",
+		false,
+		"Let sections in later config files and includes change the keys of sections "+
+			"that are already defined, instead of being ignored")
 
 	var nodaemon = flag.Bool(
 		"n",
@@ -30,11 +51,55 @@ func main() {
 		"error",
 		"The log level. Valid levels are trace, debug, info, warn, error, and critical")
 
+	var checkOnly = flag.Bool(
+		"t",
+		false,
+		"Check the config, print the effective settings and exit. Same as the 'check' command")
+
 	flag.Parse()
+	if flag.Arg(0) == "check" {
+		*checkOnly = true
+	}
+	compatOnly := flag.Arg(0) == "compat"
+	dumpOnly := flag.Arg(0) == "dumpconfig"
+	if len(supervisorConfs) == 0 {
+		supervisorConfs = configFiles{"/etc/supervisor/supervisord.conf"}
+	}
 
-	allConfig := managed_procs.LoadAllConfig(supervisorConf)
-	allConfig.SuperVisorD.Nodaemon = *nodaemon
-	allConfig.SuperVisorD.LogLevel = loglevel
+	allConfig, configErrors := managed_procs.LoadAllConfig(supervisorConfs, *override)
+	// The flags only override the config when they are given
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "n":
+			allConfig.SuperVisorD.Nodaemon = *nodaemon
+		case "e", "loglevel":
+			allConfig.SuperVisorD.LogLevel = loglevel
+		}
+	})
+
+	if compatOnly {
+		allConfig.PrintCompatReport(os.Stdout)
+	} else if dumpOnly {
+		configErrors = append(configErrors, allConfig.DumpConfig(os.Stdout, allConfig.ConfigFiles())...)
+	} else {
+		configErrors = append(configErrors, allConfig.CompatWarnings()...)
+	}
+	if *checkOnly {
+		configErrors = append(configErrors, allConfig.CheckConfig()...)
+		allConfig.PrintEffectiveConfig(os.Stdout)
+	}
+	for _, configError := range configErrors {
+		fmt.Fprintln(os.Stderr, configError)
+	}
+	if *checkOnly || compatOnly || dumpOnly {
+		if configErrors.HasErrors() {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	if configErrors.HasErrors() {
+		log.Fatalf("Refusing to start, %s has errors", supervisorConfs.String())
+	}
 
 	loggingFilename := allConfig.SuperVisorD.LogFile
 	//fmt.Printf("Supervisor is logging to %s\n", loggingFilename)
@@ -45,5 +110,8 @@ func main() {
 	defer f.Close()
 	log.SetOutput(f)
 
+	if err := allConfig.ApplySupervisorSettings(); err != nil {
+		log.Fatalf("Refusing to start: %s", err)
+	}
 	allConfig.RunAllProcesses()
 }
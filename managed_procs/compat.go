@@ -0,0 +1,339 @@
+package managed_procs
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// How much of a supervisord key supervisorgo implements
+type keySupport int
+
+const (
+	KEY_SUPPORTED keySupport = iota
+	// The key is parsed and checked but makes no difference yet
+	KEY_NOT_HONORED
+)
+
+var programKeySupport = map[string]keySupport{
+	"command":                       KEY_SUPPORTED,
+	"process_name":                  KEY_SUPPORTED,
+	"numprocs":                      KEY_SUPPORTED,
+	"numprocs_start":                KEY_SUPPORTED,
+	"priority":                      KEY_SUPPORTED,
+	"nice":                          KEY_SUPPORTED,
+	"autostart":                     KEY_SUPPORTED,
+	"startsecs":                     KEY_SUPPORTED,
+	"startretries":                  KEY_SUPPORTED,
+	"backoff_initial":               KEY_SUPPORTED,
+	"backoff_max":                   KEY_SUPPORTED,
+	"backoff_jitter":                KEY_SUPPORTED,
+	"autorestart":                   KEY_SUPPORTED,
+	"exitcodes":                     KEY_SUPPORTED,
+	"stopsignal":                    KEY_SUPPORTED,
+	"stopwaitsecs":                  KEY_SUPPORTED,
+	"stopasgroup":                   KEY_NOT_HONORED,
+	"killasgroup":                   KEY_NOT_HONORED,
+	"user":                          KEY_SUPPORTED,
+	"redirect_stderr":               KEY_NOT_HONORED,
+	"stdout_logfile":                KEY_SUPPORTED,
+	"stdout_logfile_maxbytes":       KEY_NOT_HONORED,
+	"stdout_logfile_backups":        KEY_NOT_HONORED,
+	"stdout_capture_maxbytes":       KEY_NOT_HONORED,
+	"stdout_events_enabled":         KEY_NOT_HONORED,
+	"stderr_logfile":                KEY_SUPPORTED,
+	"stderr_logfile_maxbytes":       KEY_NOT_HONORED,
+	"stderr_logfile_backups":        KEY_NOT_HONORED,
+	"stderr_capture_maxbytes":       KEY_NOT_HONORED,
+	"stderr_events_enabled":         KEY_NOT_HONORED,
+	"environment":                   KEY_SUPPORTED,
+	"env_file":                      KEY_SUPPORTED,
+	"directory":                     KEY_SUPPORTED,
+	"umask":                         KEY_SUPPORTED,
+	"serverurl":                     KEY_NOT_HONORED,
+	"extends":                       KEY_SUPPORTED,
+	"depends_on":                    KEY_SUPPORTED,
+	"readiness_probe":               KEY_SUPPORTED,
+	"readiness_probe_initial_delay": KEY_SUPPORTED,
+	"readiness_probe_interval":      KEY_SUPPORTED,
+	"readiness_probe_timeout":       KEY_SUPPORTED,
+	"readiness_probe_failures":      KEY_SUPPORTED,
+	"liveness_probe":                KEY_SUPPORTED,
+	"liveness_probe_initial_delay":  KEY_SUPPORTED,
+	"liveness_probe_interval":       KEY_SUPPORTED,
+	"liveness_probe_timeout":        KEY_SUPPORTED,
+	"liveness_probe_failures":       KEY_SUPPORTED,
+}
+
+// knownKeys are the keys each type of section can have
+var knownKeys = map[string]map[string]keySupport{
+	"supervisord": {
+		"logfile":          KEY_SUPPORTED,
+		"logfile_maxbytes": KEY_NOT_HONORED,
+		"logfile_backups":  KEY_NOT_HONORED,
+		"loglevel":         KEY_NOT_HONORED,
+		"pidfile":          KEY_NOT_HONORED,
+		"umask":            KEY_SUPPORTED,
+		"nodaemon":         KEY_NOT_HONORED,
+		"minfds":           KEY_NOT_HONORED,
+		"minprocs":         KEY_NOT_HONORED,
+		"nocleanup":        KEY_NOT_HONORED,
+		"childlogdir":      KEY_NOT_HONORED,
+		"user":             KEY_SUPPORTED,
+		"directory":        KEY_SUPPORTED,
+		"strip_ansi":       KEY_NOT_HONORED,
+		"environment":      KEY_SUPPORTED,
+		"identifier":       KEY_NOT_HONORED,
+		"exit_on":          KEY_SUPPORTED,
+		"env_file":         KEY_SUPPORTED,
+	},
+	"program":          programKeySupport,
+	"program-defaults": programKeySupport,
+	"template":         programKeySupport,
+	"eventlistener":    eventListenerKeySupport(),
+	"group": {
+		"programs": KEY_SUPPORTED,
+		"priority": KEY_SUPPORTED,
+	},
+	"include": {
+		"files": KEY_SUPPORTED,
+	},
+}
+
+// Sections supervisord has that supervisorgo ignores as a whole
+var unsupportedSections = map[string]bool{
+	"unix_http_server": true,
+	"inet_http_server": true,
+	"supervisorctl":    true,
+	"rpcinterface":     true,
+	"fcgi-program":     true,
+	"ctlplugin":        true,
+}
+
+// An event listener is run like a program, the event keys themselves aren't
+// acted on.
+func eventListenerKeySupport() map[string]keySupport {
+	keys := map[string]keySupport{
+		"buffer_size":    KEY_NOT_HONORED,
+		"events":         KEY_NOT_HONORED,
+		"result_handler": KEY_NOT_HONORED,
+	}
+	for key, support := range programKeySupport {
+		keys[key] = support
+	}
+	return keys
+}
+
+// loadedSection records the keys a section had in a file, for the
+// compatibility report
+type loadedSection struct {
+	file   string
+	name   string
+	keys   []string
+	values map[string]string
+	// Why the loader ignored the section, if it did
+	ignored string
+}
+
+func sectionType(sectionName string) string {
+	return strings.SplitN(sectionName, ":", 2)[0]
+}
+
+// sectionCompat sorts the keys of a section by how well they are supported
+type sectionCompat struct {
+	supported   []string
+	notHonored  []string
+	unknown     []string
+	unsupported bool
+	unknownType bool
+	ignored     string
+}
+
+func (section loadedSection) compat() sectionCompat {
+	var compat sectionCompat
+	if section.ignored != "" {
+		// The loader has already warned about it
+		compat.ignored = section.ignored
+		return compat
+	}
+	sectionKind := sectionType(section.name)
+	if unsupportedSections[sectionKind] {
+		compat.unsupported = true
+		return compat
+	}
+	keys, ok := knownKeys[sectionKind]
+	if !ok {
+		compat.unknownType = true
+		return compat
+	}
+	for _, key := range section.keys {
+		support, ok := keys[key]
+		if !ok {
+			compat.unknown = append(compat.unknown, key)
+		} else if support == KEY_NOT_HONORED {
+			compat.notHonored = append(compat.notHonored, key)
+		} else {
+			compat.supported = append(compat.supported, key)
+		}
+	}
+	return compat
+}
+
+// CompatWarnings warns about the config that will behave differently from
+// python supervisord: unknown keys and sections, and keys that are accepted
+// but not acted on.
+func (allConfig AllConfig) CompatWarnings() ConfigErrors {
+	var configErrors ConfigErrors
+	byFile := make(map[string]ConfigErrors)
+	var files []string
+	for _, section := range allConfig.loadedSections {
+		var sectionErrors ConfigErrors
+		compat := section.compat()
+		if compat.unsupported {
+			sectionErrors = append(sectionErrors, ConfigError{
+				Section: section.name,
+				Reason:  "section is not supported by supervisorgo, ignoring",
+				Warning: true,
+			})
+		}
+		if compat.unknownType {
+			sectionErrors = append(sectionErrors, ConfigError{
+				Section: section.name,
+				Reason:  "unknown section" + didYouMean(sectionType(section.name), sectionTypes()) + ", ignoring",
+				Warning: true,
+			})
+		}
+		for _, key := range compat.unknown {
+			sectionErrors = append(sectionErrors, ConfigError{
+				Section: section.name,
+				Key:     key,
+				Value:   section.values[key],
+				Reason:  "unknown key" + didYouMean(key, knownKeyNames(section.name)) + ", ignoring",
+				Warning: true,
+			})
+		}
+		if len(compat.notHonored) > 0 {
+			sectionErrors = append(sectionErrors, ConfigError{
+				Section: section.name,
+				Reason: fmt.Sprintf("accepted but not yet honored by supervisorgo: %s",
+					strings.Join(compat.notHonored, ", ")),
+				Warning: true,
+			})
+		}
+
+		if _, ok := byFile[section.file]; !ok {
+			files = append(files, section.file)
+		}
+		byFile[section.file] = append(byFile[section.file], sectionErrors...)
+	}
+	for _, file := range files {
+		configErrors = append(configErrors, byFile[file].inFile(file)...)
+	}
+	return configErrors
+}
+
+// PrintCompatReport lists, per file and section, which keys are supported,
+// which are accepted but not yet honored and which are unknown.
+func (allConfig AllConfig) PrintCompatReport(w io.Writer) {
+	lastFile := ""
+	for _, section := range allConfig.loadedSections {
+		if section.file != lastFile {
+			if lastFile != "" {
+				fmt.Fprintln(w)
+			}
+			fmt.Fprintln(w, section.file)
+			lastFile = section.file
+		}
+		fmt.Fprintf(w, "  [%s]\n", section.name)
+
+		compat := section.compat()
+		if compat.ignored != "" {
+			fmt.Fprintf(w, "    %s\n", compat.ignored)
+			continue
+		}
+		if compat.unsupported {
+			fmt.Fprintln(w, "    section not supported, ignored")
+			continue
+		}
+		if compat.unknownType {
+			fmt.Fprintf(w, "    unknown section%s, ignored\n", didYouMean(sectionType(section.name), sectionTypes()))
+			continue
+		}
+		if len(compat.supported) > 0 {
+			fmt.Fprintf(w, "    supported:   %s\n", strings.Join(compat.supported, ", "))
+		}
+		if len(compat.notHonored) > 0 {
+			fmt.Fprintf(w, "    not honored: %s\n", strings.Join(compat.notHonored, ", "))
+		}
+		for _, key := range compat.unknown {
+			fmt.Fprintf(w, "    unknown:     %s%s\n", key, didYouMean(key, knownKeyNames(section.name)))
+		}
+	}
+}
+
+func sectionTypes() []string {
+	var types []string
+	for sectionKind := range knownKeys {
+		types = append(types, sectionKind)
+	}
+	for sectionKind := range unsupportedSections {
+		types = append(types, sectionKind)
+	}
+	sort.Strings(types)
+	return types
+}
+
+func knownKeyNames(sectionName string) []string {
+	var keys []string
+	for key := range knownKeys[sectionType(sectionName)] {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// didYouMean suggests the closest of the candidates to a misspelt name, if
+// any is close enough to be a likely typo.
+func didYouMean(name string, candidates []string) string {
+	best := ""
+	bestDistance := len(name)/3 + 1
+	for _, candidate := range candidates {
+		distance := editDistance(strings.ToLower(name), candidate)
+		if distance < bestDistance {
+			best, bestDistance = candidate, distance
+		}
+	}
+	if best == "" {
+		return ""
+	}
+	return fmt.Sprintf(" (did you mean %s?)", best)
+}
+
+// editDistance is the Levenshtein distance between two strings
+func editDistance(a string, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = minInt(previous[j]+1, minInt(current[j-1]+1, previous[j-1]+cost))
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
+
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
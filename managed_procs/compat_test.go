@@ -0,0 +1,135 @@
+package managed_procs
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a    string
+		b    string
+		want int
+	}{
+		{"", "", 0},
+		{"", "abc", 3},
+		{"abc", "", 3},
+		{"command", "command", 0},
+		{"comand", "command", 1},
+		{"autorestrat", "autorestart", 2},
+		{"kitten", "sitting", 3},
+	}
+	for _, test := range tests {
+		if got := editDistance(test.a, test.b); got != test.want {
+			t.Errorf("editDistance(%q, %q) = %d, want %d", test.a, test.b, got, test.want)
+		}
+	}
+}
+
+func TestDidYouMean(t *testing.T) {
+	candidates := []string{"autorestart", "autostart", "command", "priority"}
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"comand", " (did you mean command?)"},
+		{"COMMAND", " (did you mean command?)"},
+		{"autostrat", " (did you mean autostart?)"},
+		{"priorty", " (did you mean priority?)"},
+		{"colour", ""},
+		{"", ""},
+	}
+	for _, test := range tests {
+		if got := didYouMean(test.name, candidates); got != test.want {
+			t.Errorf("didYouMean(%q) = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestLoadedSectionCompat(t *testing.T) {
+	tests := []struct {
+		section loadedSection
+		want    sectionCompat
+	}{
+		{
+			loadedSection{name: "program:web", keys: []string{"command", "serverurl", "comand"}},
+			sectionCompat{supported: []string{"command"}, notHonored: []string{"serverurl"}, unknown: []string{"comand"}},
+		},
+		{
+			loadedSection{name: "eventlistener:fatal", keys: []string{"command", "events"}},
+			sectionCompat{supported: []string{"command"}, notHonored: []string{"events"}},
+		},
+		{
+			loadedSection{name: "supervisord", keys: []string{"logfile", "nodaemon"}},
+			sectionCompat{supported: []string{"logfile"}, notHonored: []string{"nodaemon"}},
+		},
+		{
+			loadedSection{name: "unix_http_server", keys: []string{"file"}},
+			sectionCompat{unsupported: true},
+		},
+		{
+			loadedSection{name: "programme:web", keys: []string{"command"}},
+			sectionCompat{unknownType: true},
+		},
+	}
+	for _, test := range tests {
+		if got := test.section.compat(); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("compat() of [%s] = %+v, want %+v", test.section.name, got, test.want)
+		}
+	}
+}
+
+func TestCompatIgnoredSections(t *testing.T) {
+	dir := t.TempDir()
+	extra := `
+[supervisord]
+colour=red
+
+[program:web]
+command=/bin/other
+bogus=1
+`
+	if err := ioutil.WriteFile(filepath.Join(dir, "extra.conf"), []byte(extra), 0600); err != nil {
+		t.Fatal(err)
+	}
+	mainConfig := `
+[supervisord]
+logfile=/dev/stdout
+
+[program:web]
+command=/bin/web
+
+[include]
+files=%(here)s/extra.conf
+`
+	mainFile := filepath.Join(dir, "supervisord.conf")
+	if err := ioutil.WriteFile(mainFile, []byte(mainConfig), 0600); err != nil {
+		t.Fatal(err)
+	}
+	allConfig, configErrors := LoadAllConfig([]string{mainFile}, false)
+	if configErrors.HasErrors() {
+		t.Fatalf("LoadAllConfig returned errors %s", configErrors)
+	}
+
+	for _, configError := range allConfig.CompatWarnings() {
+		if configError.Key == "colour" || configError.Key == "bogus" {
+			t.Errorf("CompatWarnings() warned about %s in a section that was ignored: %s", configError.Key, configError)
+		}
+	}
+
+	var report bytes.Buffer
+	allConfig.PrintCompatReport(&report)
+	for _, want := range []string{
+		"  [supervisord]\n    supervisord section is only allowed in the main config file, ignoring\n",
+		"  [program:web]\n    section is duplicated, ignoring extra(s)\n",
+		"  [program:web]\n    supported:   command\n",
+	} {
+		if !strings.Contains(report.String(), want) {
+			t.Errorf("PrintCompatReport() = %s, want it to contain %q", report.String(), want)
+		}
+	}
+}
@@ -5,13 +5,15 @@ import (
 	"gopkg.in/go-ini/ini.v1"
 	"os"
 	"path/filepath"
-	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"syscall"
 )
 
 type SuperConfigSection struct {
 	LogFile         string
-	LogFileMaxBytes string
+	LogFileMaxBytes ByteSize
 	LogFileBackups  int
 	LogLevel        string
 	PidFile         string
@@ -27,6 +29,7 @@ type SuperConfigSection struct {
 	Environment     string
 	Identifier      string
 	ExitOn			string
+	EnvFile         string
 }
 
 type EventListenerConfigSection struct {
@@ -37,42 +40,78 @@ type EventListenerConfigSection struct {
 }
 
 type ProgramConfigSection struct {
+	CommandLine           string
 	Command               []string
 	ProcessName           string
 	NumProcs              int
 	NumProcsStart         int
 	Priority              int
+	Nice                  int
 	AutoStart             bool
 	StartSecs             int
 	StartRetries          int
-	AutoRestart           string
-	ExitCodes             string
-	StopSignal            string
+	BackoffInitial        int
+	BackoffMax            int
+	BackoffJitter         int
+	AutoRestart           AutoRestart
+	ExitCodes             ExitCodes
+	StopSignal            syscall.Signal
 	StopWaitSecs          int
 	StopAsGroup           bool
 	KillAsGroup           bool
 	User                  string
 	RedirectStdErr        bool
 	StdoutLogfile         string
-	StdoutLogfileMaxbytes string
+	StdoutLogfileMaxbytes ByteSize
 	StdoutLogfileBackups  int
-	StdoutCaptureMaxbytes string
+	StdoutCaptureMaxbytes ByteSize
 	StdoutEventsEnabled   bool
 	StderrLogfile         string
-	StderrLogfileMaxbytes string
+	StderrLogfileMaxbytes ByteSize
 	StderrLogfileBackups  int
-	StderrCaptureMaxbytes string
+	StderrCaptureMaxbytes ByteSize
 	StderrEventsEnabled   bool
 	Environment           string
+	EnvFile               string
 	Directory             string
 	Umask                 string
 	ServerUrl             string
+	Extends               string
+	DependsOn             []string
+	Readiness             ProbeConfig
+	Liveness              ProbeConfig
+
+	programName string
+	groupName   string
+	sectionName string
+	configFile  string
+	// keyFiles is the file each key was last set in, which differs from
+	// configFile for keys that were overridden or came from a template
+	keyFiles map[string]string
+}
+
+type GroupConfigSection struct {
+	Programs []string
+	Priority int
+
+	sectionName string
+	configFile  string
 }
 
 type AllConfig struct {
 	SuperVisorD SuperConfigSection
 	EventListeners map[string]EventListenerConfigSection
 	Programs map[string]ProgramConfigSection
+	Groups map[string]GroupConfigSection
+
+	configFiles     []string
+	includeStack    []string
+	loadedFiles     map[string]bool
+	loadedSections  []loadedSection
+	override        bool
+	programDefaults []programSource
+	templates       map[string][]programSource
+	programSources  map[string][]programSource
 }
 
 func get_config_file(supervisorConf string) (string) {
@@ -103,331 +142,754 @@ func get_config_file(supervisorConf string) (string) {
 		if err == nil {
 			return configFile
 		}
-		fmt.Printf("%s %s\n", configFile, err)
+		fmt.Fprintf(os.Stderr, "%s %s\n", configFile, err)
 	}
 
 	return ""
 }
 
-func LoadAllConfig(supervisorConf *string) (AllConfig) {
+// loadIniFile parses a config file the way python's configparser does for
+// supervisord, where ; and # only start a comment at the beginning of a line
+// or after whitespace. Otherwise values like JDBC URLs or base64 passwords
+// would be cut short.
+func loadIniFile(file string) (*ini.File, error) {
+	return ini.LoadSources(ini.LoadOptions{SpaceBeforeInlineComment: true}, file)
+}
+
+// LoadAllConfig loads the given config files in order, followed by the
+// SUPERVISOR_* environment variables. Only the first file is looked for in the
+// usual places if it doesn't exist. Sections that appear more than once are
+// ignored after the first, unless override is set, in which case the keys of
+// later ones replace those already loaded. The environment always overrides.
+func LoadAllConfig(supervisorConfs []string, override bool) (AllConfig, ConfigErrors) {
 	allConfig := AllConfig{}
-	superConfigFile := get_config_file(*supervisorConf)
 	allConfig.EventListeners = make(map[string]EventListenerConfigSection)
 	allConfig.Programs = make(map[string]ProgramConfigSection)
+	allConfig.Groups = make(map[string]GroupConfigSection)
+	allConfig.loadedFiles = make(map[string]bool)
+	allConfig.templates = make(map[string][]programSource)
+	allConfig.programSources = make(map[string][]programSource)
+	allConfig.override = override
+
+	var configErrors ConfigErrors
+	for i, supervisorConf := range supervisorConfs {
+		superConfigFile := supervisorConf
+		if i == 0 {
+			superConfigFile = get_config_file(supervisorConf)
+			if superConfigFile == "" {
+				return allConfig, ConfigErrors{{File: supervisorConf, Reason: "no configuration file found"}}
+			}
+		}
+		allConfig.configFiles = append(allConfig.configFiles, superConfigFile)
+		configErrors = append(configErrors, allConfig.loadConfigFile(superConfigFile, i == 0)...)
+	}
+	configErrors = append(configErrors, allConfig.loadEnvironment()...)
+	configErrors = append(configErrors, allConfig.ApplyTemplates()...)
+	configErrors = append(configErrors, allConfig.ResolveGroups()...)
+	configErrors = append(configErrors, allConfig.ResolveDependencies()...)
+
+	// The per instance keys can only be expanded once it is known which
+	// processes will be run, but any problems with them should still be
+	// found now.
+	for _, name := range allConfig.programNames() {
+		programSection := allConfig.Programs[name]
+		_, expandErrors := programSection.Instances()
+		configErrors = append(configErrors, expandErrors.inFile(programSection.configFile)...)
+	}
 
-	iniConfig, err := ini.Load(superConfigFile)
-	if err != nil {
-		fmt.Printf("Issue opening ini: [%s] [%v]", superConfigFile, err)
-		return allConfig
+	return allConfig, configErrors
+}
+
+// ConfigFiles are the files given on the command line as they were found,
+// i.e. with the first one being wherever the config was looked for and found
+func (allConfig AllConfig) ConfigFiles() []string {
+	return allConfig.configFiles
+}
+
+// loadConfigFile loads a file given on the command line. The [supervisord]
+// section is taken from the first one, later ones can only change it in
+// override mode.
+func (allConfig *AllConfig) loadConfigFile(superConfigFile string, first bool) ConfigErrors {
+	path := canonicalPath(superConfigFile)
+	if allConfig.loadedFiles[path] {
+		return nil
+	}
+	allConfig.loadedFiles[path] = true
+	allConfig.includeStack = []string{path}
+
+	iniConfig, configErrors := NewConfigSource(superConfigFile).Sections()
+	if iniConfig == nil {
+		return configErrors
 	}
 
 	for _, sectionName := range iniConfig.SectionStrings() {
 		//fmt.Printf("Section: %s\n", sectionName)
 		section, _ := iniConfig.GetSection(sectionName)
+		allConfig.recordSection(superConfigFile, section)
 		if sectionName == "supervisord" {
-			allConfig.LoadSuperConfig(section)
+			if first || allConfig.override {
+				configErrors = append(configErrors, expandSectionValues(section, baseExpansions(superConfigFile), nil)...)
+				configErrors = append(configErrors, allConfig.LoadSuperConfig(section)...)
+			} else {
+				configErrors = append(configErrors, allConfig.ignoreSection(superConfigFile, sectionName,
+					"supervisord section is only used from the first config file, ignoring"))
+			}
 		} else {
-			allConfig.HandleOtherConfigSections(section, sectionName)
+			configErrors = append(configErrors, allConfig.HandleOtherConfigSections(section, sectionName, superConfigFile)...)
 		}
 	}
+	return configErrors.inFile(superConfigFile)
+}
 
-	return allConfig
+func sectionSuffix(sectionName string) (string, ConfigErrors) {
+	parts := strings.SplitN(sectionName, ":", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return "", ConfigErrors{{Section: sectionName, Reason: "section name must be of the form [type:name]"}}
+	}
+	return parts[1], nil
 }
 
-func (allConfig *AllConfig) HandleOtherConfigSections(iniSection *ini.Section, sectionName string) {
-	if strings.HasPrefix(sectionName, "eventlistener") {
-		name := strings.Split(sectionName, ":")[1]
+func (allConfig *AllConfig) HandleOtherConfigSections(iniSection *ini.Section, sectionName string, configFile string) ConfigErrors {
+	var configErrors ConfigErrors
+	// Sections are told apart by their exact type, as in the compat report,
+	// so that e.g. [programs:x] is reported as unknown rather than run
+	switch sectionType(sectionName) {
+	case "eventlistener":
+		name, nameErrors := sectionSuffix(sectionName)
+		if nameErrors != nil {
+			return nameErrors
+		}
 		_, ok := allConfig.EventListeners[name]
-		if !ok {
-			allConfig.LoadEventListener(iniSection, name)
+		if !ok || allConfig.override {
+			configErrors = append(configErrors, expandSectionValues(iniSection, baseExpansions(configFile), programInstanceKeys)...)
+			configErrors = append(configErrors, allConfig.LoadEventListener(iniSection, name, configFile)...)
+			allConfig.programSources[name] = append(allConfig.programSources[name], programSource{iniSection, configFile})
 		} else {
-			fmt.Printf("Section %s is duplicated. Ignoring extra(s).\n", sectionName)
+			configErrors = append(configErrors, allConfig.ignoreSection(configFile, sectionName, "section is duplicated, ignoring extra(s)"))
+		}
+	case "program-defaults":
+		configErrors = append(configErrors, allConfig.LoadProgramDefaults(iniSection, configFile)...)
+	case "template":
+		name, nameErrors := sectionSuffix(sectionName)
+		if nameErrors != nil {
+			return nameErrors
+		}
+		configErrors = append(configErrors, allConfig.LoadTemplate(iniSection, name, configFile)...)
+	case "program":
+		name, nameErrors := sectionSuffix(sectionName)
+		if nameErrors != nil {
+			return nameErrors
 		}
-	} else if strings.HasPrefix(sectionName, "program") {
-		name := strings.Split(sectionName, ":")[1]
-		_, ok := allConfig.Programs[name]
-		if !ok {
-			programSection := GetDefaultProgramSection(name)
-			programSection.LoadProgram(iniSection, name)
+		programSection, ok := allConfig.Programs[name]
+		if !ok || allConfig.override {
+			if !ok {
+				programSection = GetDefaultProgramSection(name)
+				programSection.sectionName = sectionName
+				programSection.configFile = configFile
+			}
+			configErrors = append(configErrors, expandSectionValues(iniSection, baseExpansions(configFile), programInstanceKeys)...)
+			configErrors = append(configErrors, programSection.LoadProgram(iniSection, name)...)
 			allConfig.Programs[name] = programSection
+			allConfig.programSources[name] = append(allConfig.programSources[name], programSource{iniSection, configFile})
 		} else {
-			fmt.Printf("Section %s is duplicated. Ignoring extra(s).\n", sectionName)
+			configErrors = append(configErrors, allConfig.ignoreSection(configFile, sectionName, "section is duplicated, ignoring extra(s)"))
 		}
-	} else if sectionName == "include" {
-		if iniSection.HasKey("files") {
-			fileglobs := strings.Split(iniSection.Key("files").String(), " ")
-			for _, fileglob := range fileglobs {
-				files, err := filepath.Glob(fileglob)
-				if err == nil {
-					for _, file := range files {
-						//fmt.Printf("Loading %s\n", file)
-						includedIniConfig, err2 := ini.Load(file)
-						if err2 != nil {
-							fmt.Printf("Issue opening ini(2): [%s] [%v]\n", file, err)
-						} else {
-							for _, sectionName := range includedIniConfig.SectionStrings() {
-								//fmt.Printf("Section: %s\n", sectionName)
-								section, _ := includedIniConfig.GetSection(sectionName)
-								if sectionName == "supervisord" {
-									fmt.Printf("Ignoring supervisord section in %s\n", file)
-								} else {
-									allConfig.HandleOtherConfigSections(section, sectionName)
-								}
-							}
-						}
-					}
-				} else {
-					fmt.Printf("Fileglob error: %s\n", err)
-				}
-			}
+	case "group":
+		name, nameErrors := sectionSuffix(sectionName)
+		if nameErrors != nil {
+			return nameErrors
 		}
+		_, ok := allConfig.Groups[name]
+		if !ok || allConfig.override {
+			configErrors = append(configErrors, expandSectionValues(iniSection, baseExpansions(configFile), nil)...)
+			configErrors = append(configErrors, allConfig.LoadGroup(iniSection, name, configFile)...)
+		} else {
+			configErrors = append(configErrors, allConfig.ignoreSection(configFile, sectionName, "section is duplicated, ignoring extra(s)"))
+		}
+	case "include":
+		configErrors = append(configErrors, allConfig.LoadIncludes(iniSection, configFile)...)
 	}
+	return configErrors
 }
 
-func (allConfig *AllConfig) LoadSuperConfig(section *ini.Section) {
+// LoadIncludes loads the files matched by [include] files=. As in supervisord
+// the globs can use %(here)s and %(ENV_X)s, and relative ones are relative to
+// the directory of the file doing the including. Included files may include
+// others in turn, but a file is only ever loaded once.
+func (allConfig *AllConfig) LoadIncludes(iniSection *ini.Section, configFile string) ConfigErrors {
+	var configErrors ConfigErrors
+	if !iniSection.HasKey("files") {
+		return configErrors
+	}
+
+	value := iniSection.Key("files").Value()
+	fileglobs, err := expandString(value, baseExpansions(configFile))
+	if err != nil {
+		return append(configErrors, ConfigError{
+			Section: iniSection.Name(),
+			Key:     "files",
+			Value:   value,
+			Reason:  err.Error(),
+		})
+	}
+	for _, fileglob := range strings.Fields(fileglobs) {
+		pattern := fileglob
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(filepath.Dir(configFile), pattern)
+		}
+		files, err := filepath.Glob(pattern)
+		if err != nil {
+			configErrors = append(configErrors, ConfigError{
+				Section: iniSection.Name(),
+				Key:     "files",
+				Value:   fileglob,
+				Reason:  fmt.Sprintf("bad file glob: %s", err),
+			})
+			continue
+		}
+		if len(files) == 0 {
+			configErrors = append(configErrors, ConfigError{
+				Section: iniSection.Name(),
+				Key:     "files",
+				Value:   fileglob,
+				Reason:  fmt.Sprintf("%s doesn't match any files", pattern),
+				Warning: true,
+			})
+			continue
+		}
+
+		sort.Strings(files)
+		for _, file := range files {
+			configErrors = append(configErrors, allConfig.loadIncludedFile(iniSection, file)...)
+		}
+	}
+	return configErrors
+}
+
+func (allConfig *AllConfig) recordSection(file string, section *ini.Section) {
+	if section.Name() == ini.DEFAULT_SECTION && len(section.KeyStrings()) == 0 {
+		return
+	}
+	allConfig.loadedSections = append(allConfig.loadedSections, loadedSection{
+		file:   file,
+		name:   section.Name(),
+		keys:   section.KeyStrings(),
+		values: section.KeysHash(),
+	})
+}
+
+// ignoreSection is the warning for a section the loader doesn't use. The
+// section is marked as ignored for the compat report too, which otherwise
+// would list its keys as if they were in effect.
+func (allConfig *AllConfig) ignoreSection(file string, sectionName string, reason string) ConfigError {
+	for i := len(allConfig.loadedSections) - 1; i >= 0; i-- {
+		if allConfig.loadedSections[i].file == file && allConfig.loadedSections[i].name == sectionName {
+			allConfig.loadedSections[i].ignored = reason
+			break
+		}
+	}
+	return ConfigError{Section: sectionName, Reason: reason, Warning: true}
+}
+
+func canonicalPath(file string) string {
+	if resolved, err := filepath.EvalSymlinks(file); err == nil {
+		file = resolved
+	}
+	if absolute, err := filepath.Abs(file); err == nil {
+		file = absolute
+	}
+	return file
+}
+
+func (allConfig *AllConfig) loadIncludedFile(includeSection *ini.Section, file string) ConfigErrors {
+	path := canonicalPath(file)
+	for i, including := range allConfig.includeStack {
+		if including == path {
+			cycle := append(append([]string{}, allConfig.includeStack[i:]...), path)
+			return ConfigErrors{{
+				Section: includeSection.Name(),
+				Key:     "files",
+				Value:   file,
+				Reason:  fmt.Sprintf("include cycle: %s", strings.Join(cycle, " -> ")),
+			}}
+		}
+	}
+	if allConfig.loadedFiles[path] {
+		// Already matched by another glob or included from another file
+		return nil
+	}
+	allConfig.loadedFiles[path] = true
+
+	//fmt.Printf("Loading %s\n", file)
+	includedIniConfig, includedErrors := NewConfigSource(file).Sections()
+	if includedIniConfig == nil {
+		return includedErrors
+	}
+
+	allConfig.includeStack = append(allConfig.includeStack, path)
+	defer func() {
+		allConfig.includeStack = allConfig.includeStack[:len(allConfig.includeStack)-1]
+	}()
+
+	for _, sectionName := range includedIniConfig.SectionStrings() {
+		//fmt.Printf("Section: %s\n", sectionName)
+		section, _ := includedIniConfig.GetSection(sectionName)
+		allConfig.recordSection(file, section)
+		if sectionName == "supervisord" {
+			includedErrors = append(includedErrors, allConfig.ignoreSection(file, sectionName,
+				"supervisord section is only allowed in the main config file, ignoring"))
+		} else {
+			includedErrors = append(includedErrors, allConfig.HandleOtherConfigSections(section, sectionName, file)...)
+		}
+	}
+	return includedErrors.inFile(file)
+}
+
+func (allConfig *AllConfig) LoadSuperConfig(section *ini.Section) ConfigErrors {
 	var err error
+	var configErrors ConfigErrors
 	for _, key := range section.KeyStrings() {
 		//fmt.Printf("		%s = %v\n", key, section.Key(key))
 
 		if key == "logfile" {
-			allConfig.SuperVisorD.LogFile = section.Key(key).String()
+			allConfig.SuperVisorD.LogFile = section.Key(key).Value()
 		} else if key == "logfile_maxbytes" {
-			allConfig.SuperVisorD.LogFileMaxBytes = section.Key(key).String()
+			allConfig.SuperVisorD.LogFileMaxBytes, err = ParseByteSize(section.Key(key).Value())
 		} else if key == "logfile_backups" {
-			allConfig.SuperVisorD.LogFileBackups, err = section.Key(key).Int()
+			allConfig.SuperVisorD.LogFileBackups, err = keyInt(section.Key(key))
 		} else if key == "loglevel" {
-			allConfig.SuperVisorD.LogLevel = section.Key(key).String()
+			allConfig.SuperVisorD.LogLevel = section.Key(key).Value()
 		} else if key == "pidfile" {
-			allConfig.SuperVisorD.PidFile = section.Key(key).String()
+			allConfig.SuperVisorD.PidFile = section.Key(key).Value()
 		} else if key == "umask" {
-			allConfig.SuperVisorD.Umask = section.Key(key).String()
+			allConfig.SuperVisorD.Umask = section.Key(key).Value()
+			_, err = ParseUmask(allConfig.SuperVisorD.Umask)
 		} else if key == "nodaemon" {
-			allConfig.SuperVisorD.Nodaemon, err = section.Key(key).Bool()
+			allConfig.SuperVisorD.Nodaemon, err = keyBool(section.Key(key))
 		} else if key == "minfds" {
-			allConfig.SuperVisorD.Minfds, err = section.Key(key).Int()
+			allConfig.SuperVisorD.Minfds, err = keyInt(section.Key(key))
 		} else if key == "minprocs" {
-			allConfig.SuperVisorD.MinProcs, err = section.Key(key).Int()
+			allConfig.SuperVisorD.MinProcs, err = keyInt(section.Key(key))
 		} else if key == "nocleanup" {
-			allConfig.SuperVisorD.Nocleanup, err = section.Key(key).Bool()
+			allConfig.SuperVisorD.Nocleanup, err = keyBool(section.Key(key))
 		} else if key == "childlogdir" {
-			allConfig.SuperVisorD.ChildLogDir = section.Key(key).String()
+			allConfig.SuperVisorD.ChildLogDir = section.Key(key).Value()
 		} else if key == "user" {
-			allConfig.SuperVisorD.User = section.Key(key).String()
+			allConfig.SuperVisorD.User = section.Key(key).Value()
 		} else if key == "directory" {
-			allConfig.SuperVisorD.Directory = section.Key(key).String()
+			allConfig.SuperVisorD.Directory = section.Key(key).Value()
 		} else if key == "strip_ansi" {
-			allConfig.SuperVisorD.StripAnsi, err = section.Key(key).Bool()
+			allConfig.SuperVisorD.StripAnsi, err = keyBool(section.Key(key))
 		} else if key == "environment" {
-			allConfig.SuperVisorD.Environment = section.Key(key).String()
+			allConfig.SuperVisorD.Environment = section.Key(key).Value()
 		} else if key == "identifier" {
-			allConfig.SuperVisorD.Identifier = section.Key(key).String()
+			allConfig.SuperVisorD.Identifier = section.Key(key).Value()
 		} else if key == "exit_on" {
-			allConfig.SuperVisorD.ExitOn = section.Key(key).String()
+			allConfig.SuperVisorD.ExitOn = section.Key(key).Value()
+		} else if key == "env_file" {
+			allConfig.SuperVisorD.EnvFile = section.Key(key).Value()
 		}
 
 		if err != nil {
-			fmt.Printf("WARNING: Trouble converting that key\n")
+			configErrors = append(configErrors, keyError(section.Name(), key, section.Key(key).Value(), err))
 			err = nil
 		}
 	}
+	return configErrors
+}
+
+// go-ini has its own %(name)s interpolation, which knows nothing about the
+// names supervisord provides, so values are always read raw and expanded by
+// expandString instead.
+func keyInt(key *ini.Key) (int, error) {
+	return strconv.Atoi(strings.TrimSpace(key.Value()))
+}
+
+// keySeconds is a number of seconds, which can't be negative
+func keySeconds(key *ini.Key) (int, error) {
+	seconds, err := strconv.Atoi(strings.TrimSpace(key.Value()))
+	if err != nil || seconds < 0 {
+		return 0, fmt.Errorf("not a valid number of seconds")
+	}
+	return seconds, nil
+}
+
+func keyNonNegativeInt(key *ini.Key) (int, error) {
+	value, err := strconv.Atoi(strings.TrimSpace(key.Value()))
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("not a valid number, expected 0 or more")
+	}
+	return value, nil
+}
+
+func keyPositiveInt(key *ini.Key) (int, error) {
+	value, err := strconv.Atoi(strings.TrimSpace(key.Value()))
+	if err != nil || value < 1 {
+		return 0, fmt.Errorf("not a valid number, expected 1 or more")
+	}
+	return value, nil
+}
+
+func keyBool(key *ini.Key) (bool, error) {
+	switch strings.ToLower(strings.TrimSpace(key.Value())) {
+	case "true", "yes", "on", "1":
+		return true, nil
+	case "false", "no", "off", "0":
+		return false, nil
+	}
+	return false, fmt.Errorf("not a valid boolean")
 }
 
 func GetDefaultProgramSection(name string) (ProgramConfigSection) {
 	// set defaults
 	programSection := ProgramConfigSection{
-		ProcessName: name,
+		ProcessName: "%(program_name)s",
 		NumProcs: 1,
 		NumProcsStart: 0,
 		Priority: 999,
+		Nice: 0,
 		AutoStart: true,
 		StartSecs: 1,
 		StartRetries: 3,
-		AutoRestart: "unexpected",
-		ExitCodes: "0,2",
-		StopSignal: "TERM",
+		BackoffInitial: 1,
+		BackoffMax: 0,
+		BackoffJitter: 0,
+		AutoRestart: AUTORESTART_UNEXPECTED,
+		ExitCodes: ExitCodes{0, 2},
+		StopSignal: syscall.SIGTERM,
 		StopWaitSecs: 10,
 		StopAsGroup: false,
 		KillAsGroup: false,
 		User: "",
 		RedirectStdErr: false,
 		StdoutLogfile: "AUTO",
-		StdoutLogfileMaxbytes: "50MB",
+		StdoutLogfileMaxbytes: 50 * MEGABYTE,
 		StdoutLogfileBackups: 10,
-		StdoutCaptureMaxbytes: "50MB",
+		StdoutCaptureMaxbytes: 50 * MEGABYTE,
 		StdoutEventsEnabled: false,
 		StderrLogfile: "AUTO",
-		StderrLogfileMaxbytes: "50MB",
+		StderrLogfileMaxbytes: 50 * MEGABYTE,
 		StderrLogfileBackups: 10,
+		StderrCaptureMaxbytes: 50 * MEGABYTE,
 		StderrEventsEnabled: false,
 		Environment: "",
 		Directory: "",
 		Umask: "",
 		ServerUrl: "AUTO",
+		Readiness: defaultProbeConfig(),
+		Liveness: defaultProbeConfig(),
+		programName: name,
+		groupName: name,
+		sectionName: "program:" + name,
 	}
 	return programSection
 }
 
-func replaceCommandEnvars (origString string) string {
-	re := regexp.MustCompile(`%\((.*?)\)s`)
-	return re.ReplaceAllStringFunc(origString, stripAndGetEnv)
-}
-
-func stripAndGetEnv(toreplace string) string {
-	re := regexp.MustCompile(`%\((.*?)\)s`)
-	toreplace = re.ReplaceAllString(toreplace, "${1}")
-	return os.Getenv(toreplace)
+// keyFile is the file the key was set in, or the file of the section if it
+// wasn't set anywhere
+func (configFileSection ProgramConfigSection) keyFile(key string) string {
+	if file, ok := configFileSection.keyFiles[key]; ok {
+		return file
+	}
+	return configFileSection.configFile
 }
 
-func (configFileSection *ProgramConfigSection) LoadProgram(section *ini.Section, name string) {
+func (configFileSection *ProgramConfigSection) LoadProgram(section *ini.Section, name string) ConfigErrors {
 	var err error
+	var configErrors ConfigErrors
 	for _, key := range section.KeyStrings() {
 		//fmt.Printf("		%s = %v\n", key, section.Key(key))
 
-		// If something is quoted, the quotes should be stripped and the content of the quotes should become 1 arg.
-		// i.e. bash -c "source stuff && do/otherStuff.sh"
-		// becomes ["bash", "-c", "source stuff && do/otherStuff.sh"], not
-		// becomes ["bash", "-c", "\"source stuff && do/otherStuff.sh\""]
-
 		if key == "command" {
-			value := *section.Key(key)
-			commandParts := strings.Split(replaceCommandEnvars(value.Value()), " ")
-			var realCommandParts []string
-			inQuotes := false
-			quoted := ""
-			for _, commandPart := range commandParts {
-				if commandPart == "" {
-					continue
-				}
-				if ! inQuotes && commandPart[0] == '"' {
-					inQuotes = true
-					quoted = commandPart[1:]
-				} else if inQuotes && commandPart[len(commandPart)-1] != '"' {
-					quoted = fmt.Sprintf("%s %s", quoted, commandPart)
-				} else if inQuotes {
-					inQuotes = false
-					quoted = fmt.Sprintf("%s %s", quoted, commandPart[:len(commandPart)-1])
-					realCommandParts = append(realCommandParts, quoted)
-				} else {
-					realCommandParts = append(realCommandParts, commandPart)
-				}
-			}
-			configFileSection.Command = append(configFileSection.Command, realCommandParts...)
+			configFileSection.CommandLine = section.Key(key).Value()
 		} else if key == "process_name" {
-			configFileSection.ProcessName = section.Key(key).String()
+			configFileSection.ProcessName = section.Key(key).Value()
 		} else if key == "numprocs" {
-			configFileSection.NumProcs, err = section.Key(key).Int()
+			configFileSection.NumProcs, err = keyInt(section.Key(key))
 		} else if key == "numprocs_start" {
-			configFileSection.NumProcsStart, err = section.Key(key).Int()
+			configFileSection.NumProcsStart, err = keyInt(section.Key(key))
 		} else if key == "priority" {
-			configFileSection.Priority, err = section.Key(key).Int()
+			configFileSection.Priority, err = keyInt(section.Key(key))
+		} else if key == "nice" {
+			configFileSection.Nice, err = ParseNice(section.Key(key).Value())
 		} else if key == "autostart" {
-			configFileSection.AutoStart, err = section.Key(key).Bool()
+			configFileSection.AutoStart, err = keyBool(section.Key(key))
 		} else if key == "startsecs" {
-			configFileSection.StartSecs, err = section.Key(key).Int()
+			configFileSection.StartSecs, err = keySeconds(section.Key(key))
 		} else if key == "startretries" {
-			configFileSection.StartRetries, err = section.Key(key).Int()
+			configFileSection.StartRetries, err = keyNonNegativeInt(section.Key(key))
+		} else if key == "backoff_initial" {
+			configFileSection.BackoffInitial, err = keySeconds(section.Key(key))
+		} else if key == "backoff_max" {
+			configFileSection.BackoffMax, err = keySeconds(section.Key(key))
+		} else if key == "backoff_jitter" {
+			configFileSection.BackoffJitter, err = keySeconds(section.Key(key))
 		} else if key == "autorestart" {
-			configFileSection.AutoRestart = section.Key(key).String()
+			configFileSection.AutoRestart, err = ParseAutoRestart(section.Key(key).Value())
 		} else if key == "exitcodes" {
-			configFileSection.ExitCodes = section.Key(key).String()
+			configFileSection.ExitCodes, err = ParseExitCodes(section.Key(key).Value())
 		} else if key == "stopsignal" {
-			configFileSection.StopSignal = section.Key(key).String()
+			configFileSection.StopSignal, err = ParseSignal(section.Key(key).Value())
 		} else if key == "stopwaitsecs" {
-			configFileSection.StopWaitSecs, err = section.Key(key).Int()
+			configFileSection.StopWaitSecs, err = keySeconds(section.Key(key))
 		} else if key == "stopasgroup" {
-			configFileSection.StopAsGroup, err = section.Key(key).Bool()
+			configFileSection.StopAsGroup, err = keyBool(section.Key(key))
 		} else if key == "killasgroup" {
-			configFileSection.KillAsGroup, err = section.Key(key).Bool()
+			configFileSection.KillAsGroup, err = keyBool(section.Key(key))
 		} else if key == "user" {
-			configFileSection.User = section.Key(key).String()
+			configFileSection.User = section.Key(key).Value()
 		} else if key == "redirect_stderr" {
-			configFileSection.RedirectStdErr, err = section.Key(key).Bool()
+			configFileSection.RedirectStdErr, err = keyBool(section.Key(key))
 		} else if key == "stdout_logfile" {
-			configFileSection.StdoutLogfile = section.Key(key).String()
+			configFileSection.StdoutLogfile = section.Key(key).Value()
 		} else if key == "stdout_logfile_maxbytes" {
-			configFileSection.StdoutLogfileMaxbytes = section.Key(key).String()
+			configFileSection.StdoutLogfileMaxbytes, err = ParseByteSize(section.Key(key).Value())
 		} else if key == "stdout_logfile_backups" {
-			configFileSection.StdoutLogfileBackups, err = section.Key(key).Int()
+			configFileSection.StdoutLogfileBackups, err = keyInt(section.Key(key))
 		} else if key == "stdout_capture_maxbytes" {
-			configFileSection.StdoutCaptureMaxbytes = section.Key(key).String()
+			configFileSection.StdoutCaptureMaxbytes, err = ParseByteSize(section.Key(key).Value())
 		} else if key == "stdout_events_enabled" {
-			configFileSection.StdoutEventsEnabled, err = section.Key(key).Bool()
+			configFileSection.StdoutEventsEnabled, err = keyBool(section.Key(key))
 		} else if key == "stderr_logfile" {
-			configFileSection.StderrLogfile = section.Key(key).String()
+			configFileSection.StderrLogfile = section.Key(key).Value()
 		} else if key == "stderr_logfile_maxbytes" {
-			configFileSection.StderrLogfileMaxbytes = section.Key(key).String()
+			configFileSection.StderrLogfileMaxbytes, err = ParseByteSize(section.Key(key).Value())
 		} else if key == "stderr_logfile_backups" {
-			configFileSection.StderrLogfileBackups, err = section.Key(key).Int()
+			configFileSection.StderrLogfileBackups, err = keyInt(section.Key(key))
 		} else if key == "stderr_capture_maxbytes" {
-			configFileSection.StderrCaptureMaxbytes = section.Key(key).String()
+			configFileSection.StderrCaptureMaxbytes, err = ParseByteSize(section.Key(key).Value())
 		} else if key == "stderr_events_enabled" {
-			configFileSection.StderrEventsEnabled, err = section.Key(key).Bool()
+			configFileSection.StderrEventsEnabled, err = keyBool(section.Key(key))
 		} else if key == "environment" {
-			configFileSection.Environment = section.Key(key).String()
+			configFileSection.Environment = section.Key(key).Value()
 		} else if key == "directory" {
-			configFileSection.Directory = section.Key(key).String()
+			configFileSection.Directory = section.Key(key).Value()
 		} else if key == "umask" {
-			configFileSection.Umask = section.Key(key).String()
+			configFileSection.Umask = section.Key(key).Value()
+			_, err = ParseUmask(configFileSection.Umask)
 		} else if key == "serverurl" {
-			configFileSection.ServerUrl = section.Key(key).String()
+			configFileSection.ServerUrl = section.Key(key).Value()
+		} else if key == "env_file" {
+			configFileSection.EnvFile = section.Key(key).Value()
+		} else if key == "extends" {
+			configFileSection.Extends = strings.TrimSpace(section.Key(key).Value())
+		} else if key == "readiness_probe" {
+			configFileSection.Readiness.Line = section.Key(key).Value()
+		} else if key == "readiness_probe_initial_delay" {
+			configFileSection.Readiness.InitialDelay, err = keySeconds(section.Key(key))
+		} else if key == "readiness_probe_interval" {
+			configFileSection.Readiness.Interval, err = keyPositiveInt(section.Key(key))
+		} else if key == "readiness_probe_timeout" {
+			configFileSection.Readiness.Timeout, err = keyPositiveInt(section.Key(key))
+		} else if key == "readiness_probe_failures" {
+			configFileSection.Readiness.Failures, err = keyPositiveInt(section.Key(key))
+		} else if key == "liveness_probe" {
+			configFileSection.Liveness.Line = section.Key(key).Value()
+		} else if key == "liveness_probe_initial_delay" {
+			configFileSection.Liveness.InitialDelay, err = keySeconds(section.Key(key))
+		} else if key == "liveness_probe_interval" {
+			configFileSection.Liveness.Interval, err = keyPositiveInt(section.Key(key))
+		} else if key == "liveness_probe_timeout" {
+			configFileSection.Liveness.Timeout, err = keyPositiveInt(section.Key(key))
+		} else if key == "liveness_probe_failures" {
+			configFileSection.Liveness.Failures, err = keyPositiveInt(section.Key(key))
+		} else if key == "depends_on" {
+			configFileSection.DependsOn = nil
+			for _, dependency := range strings.Split(section.Key(key).Value(), ",") {
+				dependency = strings.TrimSpace(dependency)
+				if dependency != "" {
+					configFileSection.DependsOn = append(configFileSection.DependsOn, dependency)
+				}
+			}
 		}
 
 		if err != nil {
-			fmt.Printf("WARNING: Trouble converting that key\n")
+			configErrors = append(configErrors, keyError(section.Name(), key, section.Key(key).Value(), err))
 			err = nil
 		}
 
 	}
+	return configErrors
 }
 
-func (allConfig *AllConfig) LoadEventListener(section *ini.Section, name string) {
+func (allConfig *AllConfig) LoadEventListener(section *ini.Section, name string, configFile string) ConfigErrors {
 	var err error
 
-	programSection := GetDefaultProgramSection(name)
-	programSection.LoadProgram(section, name)
+	programSection, ok := allConfig.Programs[name]
+	if !ok {
+		programSection = GetDefaultProgramSection(name)
+		programSection.sectionName = section.Name()
+		programSection.configFile = configFile
+	}
+	configErrors := programSection.LoadProgram(section, name)
 	// The event listener is another program to run...
 	allConfig.Programs[name] = programSection
 
-	eventListenerSection := EventListenerConfigSection{
-		BufferSize: "",
-		Events: "",
-		ResultHandler: "",
-		ProgramData: programSection,
+	eventListenerSection, ok := allConfig.EventListeners[name]
+	if !ok {
+		eventListenerSection = EventListenerConfigSection{
+			BufferSize: "",
+			Events: "",
+			ResultHandler: "",
+		}
 	}
+	eventListenerSection.ProgramData = programSection
 
 	for _, key := range section.KeyStrings() {
 		//fmt.Printf("		%s = %v\n", key, section.Key(key))
 
 		if key == "buffer_size" {
-			eventListenerSection.BufferSize = section.Key(key).String()
+			eventListenerSection.BufferSize = section.Key(key).Value()
 		} else if key == "events" {
-			eventListenerSection.Events = section.Key(key).String()
+			eventListenerSection.Events = section.Key(key).Value()
 		} else if key == "result_handler" {
-			eventListenerSection.ResultHandler = section.Key(key).String()
+			eventListenerSection.ResultHandler = section.Key(key).Value()
 		}
 
 		if err != nil {
-			fmt.Printf("WARNING: Trouble converting that key\n")
+			configErrors = append(configErrors, keyError(section.Name(), key, section.Key(key).Value(), err))
 			err = nil
 		}
 
 	}
 	allConfig.EventListeners[name] = eventListenerSection
+	return configErrors
+}
+
+// GetEnvarMap returns the variables the config sets for the program: those
+// from the env_file and environment= of [supervisord], then from its own
+// env_file and environment=, each overriding the ones before.
+func (configFileSection *ProgramConfigSection) GetEnvarMap(superConfig SuperConfigSection) (map[string]string, error) {
+	envarmap, err := superConfig.GetEnvarMap()
+	if err != nil {
+		return nil, err
+	}
+	if err := addEnvironment(envarmap, configFileSection.EnvFile, configFileSection.Environment); err != nil {
+		return nil, err
+	}
+	return envarmap, nil
 }
 
-func (configFileSection *ProgramConfigSection) GetEnvarMap() map[string]string {
+// GetEnvarMap returns the variables [supervisord] sets with its env_file and
+// environment=, for itself and every program.
+func (superConfig SuperConfigSection) GetEnvarMap() (map[string]string, error) {
 	envarmap := make(map[string]string)
-	for _, envar := range strings.Split(configFileSection.Environment, ",") {
-		envar_keyval := strings.Split(envar, "=")
-		if len(envar_keyval) == 2 {
-			key := envar_keyval[0]
-			val := envar_keyval[1]
-			if len(val) > 1 && strings.HasPrefix(val, "\"")  && strings.HasSuffix(val, "\"") {
-				val = strings.TrimPrefix(val, "\"")
-				val = strings.TrimSuffix(val, "\"")
-			}
+	if err := addEnvironment(envarmap, superConfig.EnvFile, superConfig.Environment); err != nil {
+		return nil, err
+	}
+	return envarmap, nil
+}
+
+// addEnvironment adds the variables from the env_files, then from
+// environment=, to envarmap.
+func addEnvironment(envarmap map[string]string, envFiles string, environment string) error {
+	for _, envFile := range splitEnvFiles(envFiles) {
+		fileEnvarmap, err := parseEnvFile(envFile)
+		if err != nil {
+			return err
+		}
+		for key, val := range fileEnvarmap {
+			addSecret(val, secretSource{path: envFile})
 			envarmap[key] = val
 		}
 	}
-	return envarmap
-}
\ No newline at end of file
+
+	parsed, err := parseEnvironment(environment)
+	if err != nil {
+		return err
+	}
+	for key, val := range parsed {
+		envarmap[key] = val
+	}
+	return nil
+}
+
+func (allConfig *AllConfig) LoadGroup(section *ini.Section, name string, configFile string) ConfigErrors {
+	var err error
+	var configErrors ConfigErrors
+
+	groupSection, ok := allConfig.Groups[name]
+	if !ok {
+		groupSection = GroupConfigSection{
+			Priority:    999,
+			sectionName: section.Name(),
+			configFile:  configFile,
+		}
+	}
+
+	for _, key := range section.KeyStrings() {
+		if key == "programs" {
+			groupSection.Programs = nil
+			for _, program := range strings.Split(section.Key(key).Value(), ",") {
+				program = strings.TrimSpace(program)
+				if program != "" {
+					groupSection.Programs = append(groupSection.Programs, program)
+				}
+			}
+		} else if key == "priority" {
+			groupSection.Priority, err = keyInt(section.Key(key))
+		}
+
+		if err != nil {
+			configErrors = append(configErrors, keyError(section.Name(), key, section.Key(key).Value(), err))
+			err = nil
+		}
+	}
+
+	if len(groupSection.Programs) == 0 {
+		configErrors = append(configErrors, ConfigError{
+			Section: section.Name(),
+			Key:     "programs",
+			Reason:  "a group needs at least one program",
+		})
+	}
+	allConfig.Groups[name] = groupSection
+	return configErrors
+}
+
+// ResolveGroups puts each program listed in a [group:x] section into that
+// group. Programs that aren't listed anywhere are in a group of their own,
+// named after the program, as in supervisord.
+func (allConfig *AllConfig) ResolveGroups() ConfigErrors {
+	var configErrors ConfigErrors
+	grouped := make(map[string]string)
+	for _, groupName := range allConfig.groupNames() {
+		groupSection := allConfig.Groups[groupName]
+		var groupErrors ConfigErrors
+		for _, programName := range groupSection.Programs {
+			programSection, ok := allConfig.Programs[programName]
+			if !ok {
+				groupErrors = append(groupErrors, ConfigError{
+					Section: groupSection.sectionName,
+					Key:     "programs",
+					Value:   programName,
+					Reason:  "no such program",
+				})
+				continue
+			}
+			if otherGroup, ok := grouped[programName]; ok {
+				groupErrors = append(groupErrors, ConfigError{
+					Section: groupSection.sectionName,
+					Key:     "programs",
+					Value:   programName,
+					Reason:  fmt.Sprintf("program is already in group %s", otherGroup),
+				})
+				continue
+			}
+			grouped[programName] = groupName
+			programSection.groupName = groupName
+			allConfig.Programs[programName] = programSection
+		}
+		configErrors = append(configErrors, groupErrors.inFile(groupSection.configFile)...)
+	}
+	return configErrors
+}
+
+// GroupPriority is the priority of the group the program is in, which for a
+// program that isn't in a [group:x] section is its own priority.
+func (allConfig AllConfig) GroupPriority(programSection ProgramConfigSection) int {
+	if groupSection, ok := allConfig.Groups[programSection.groupName]; ok {
+		return groupSection.Priority
+	}
+	return programSection.Priority
+}
@@ -0,0 +1,258 @@
+package managed_procs
+
+import (
+	"fmt"
+	"io"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type configSetting struct {
+	Key   string
+	Value string
+}
+
+// CheckConfig looks for problems that only show up once the configuration
+// is put to use, such as commands that can't be found.
+func (allConfig AllConfig) CheckConfig() ConfigErrors {
+	var configErrors ConfigErrors
+	for _, envFile := range splitEnvFiles(allConfig.SuperVisorD.EnvFile) {
+		if _, err := parseEnvFile(envFile); err != nil {
+			configErrors = append(configErrors, ConfigError{
+				Section: "supervisord",
+				Key:     "env_file",
+				Value:   envFile,
+				Reason:  err.Error(),
+			})
+		}
+	}
+	if directory := allConfig.SuperVisorD.Directory; directory != "" {
+		if err := checkDirectory(directory); err != nil {
+			configErrors = append(configErrors, ConfigError{
+				Section: "supervisord",
+				Key:     "directory",
+				Value:   directory,
+				Reason:  err.Error(),
+			})
+		}
+	}
+	if userName := allConfig.SuperVisorD.User; userName != "" {
+		if _, err := lookupCredential(userName); err != nil {
+			configErrors = append(configErrors, ConfigError{
+				Section: "supervisord",
+				Key:     "user",
+				Value:   userName,
+				Reason:  err.Error(),
+			})
+		}
+	}
+	for _, name := range allConfig.programNames() {
+		instances, _ := allConfig.Programs[name].Instances()
+		for _, programConfig := range instances {
+			// The directory may yet be created, until then the process is FATAL
+			if programConfig.Directory != "" {
+				if err := checkDirectory(programConfig.Directory); err != nil {
+					configErrors = append(configErrors, ConfigError{
+						Section: programConfig.sectionName,
+						Key:     "directory",
+						Value:   programConfig.Directory,
+						Reason:  err.Error(),
+						Warning: true,
+					})
+				}
+			}
+			if programConfig.User != "" {
+				if _, err := lookupCredential(programConfig.User); err != nil {
+					configErrors = append(configErrors, ConfigError{
+						Section: programConfig.sectionName,
+						Key:     "user",
+						Value:   programConfig.User,
+						Reason:  err.Error(),
+					})
+				}
+			}
+			for _, envFile := range splitEnvFiles(programConfig.EnvFile) {
+				if _, err := parseEnvFile(envFile); err != nil {
+					configErrors = append(configErrors, ConfigError{
+						Section: programConfig.sectionName,
+						Key:     "env_file",
+						Value:   envFile,
+						Reason:  err.Error(),
+					})
+				}
+			}
+			if len(programConfig.Command) == 0 {
+				configErrors = append(configErrors, ConfigError{
+					Section: programConfig.sectionName,
+					Key:     "command",
+					Reason:  "no command specified",
+				})
+				break
+			}
+			if _, err := exec.LookPath(programConfig.Command[0]); err != nil {
+				configErrors = append(configErrors, ConfigError{
+					Section: programConfig.sectionName,
+					Key:     "command",
+					Value:   programConfig.Command[0],
+					Reason:  "executable not found",
+				})
+				break
+			}
+		}
+	}
+	return configErrors
+}
+
+// PrintEffectiveConfig writes out the settings each process will actually be
+// run with, after includes, defaults and expansion have been applied. A
+// program with numprocs > 1 is printed once per process.
+func (allConfig AllConfig) PrintEffectiveConfig(w io.Writer) {
+	printSection(w, "supervisord", allConfig.SuperVisorD.settings())
+	for _, name := range allConfig.groupNames() {
+		printSection(w, "group:"+name, allConfig.Groups[name].settings())
+	}
+	for _, name := range allConfig.programNames() {
+		instances, _ := allConfig.Programs[name].Instances()
+		for _, programConfig := range instances {
+			if eventListener, ok := allConfig.EventListeners[name]; ok {
+				settings := append(programConfig.settings(),
+					configSetting{"buffer_size", eventListener.BufferSize},
+					configSetting{"events", eventListener.Events},
+					configSetting{"result_handler", eventListener.ResultHandler},
+				)
+				printSection(w, programConfig.sectionName, settings)
+			} else {
+				printSection(w, programConfig.sectionName, programConfig.settings())
+			}
+		}
+	}
+}
+
+func printSection(w io.Writer, sectionName string, settings []configSetting) {
+	fmt.Fprintf(w, "[%s]\n", sectionName)
+	for _, setting := range settings {
+		fmt.Fprintf(w, "%s = %s\n", setting.Key, redactSecrets(setting.Value))
+	}
+	fmt.Fprintln(w)
+}
+
+func (allConfig AllConfig) programNames() []string {
+	var names []string
+	for name := range allConfig.Programs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (allConfig AllConfig) groupNames() []string {
+	var names []string
+	for name := range allConfig.Groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (groupConfig GroupConfigSection) settings() []configSetting {
+	return []configSetting{
+		{"programs", strings.Join(groupConfig.Programs, ",")},
+		{"priority", strconv.Itoa(groupConfig.Priority)},
+	}
+}
+
+func (superConfig SuperConfigSection) settings() []configSetting {
+	return []configSetting{
+		{"logfile", superConfig.LogFile},
+		{"logfile_maxbytes", superConfig.LogFileMaxBytes.String()},
+		{"logfile_backups", strconv.Itoa(superConfig.LogFileBackups)},
+		{"loglevel", superConfig.LogLevel},
+		{"pidfile", superConfig.PidFile},
+		{"umask", superConfig.Umask},
+		{"nodaemon", strconv.FormatBool(superConfig.Nodaemon)},
+		{"minfds", strconv.Itoa(superConfig.Minfds)},
+		{"minprocs", strconv.Itoa(superConfig.MinProcs)},
+		{"nocleanup", strconv.FormatBool(superConfig.Nocleanup)},
+		{"childlogdir", superConfig.ChildLogDir},
+		{"user", superConfig.User},
+		{"directory", superConfig.Directory},
+		{"strip_ansi", strconv.FormatBool(superConfig.StripAnsi)},
+		{"environment", superConfig.Environment},
+		{"identifier", superConfig.Identifier},
+		{"exit_on", superConfig.ExitOn},
+		{"env_file", superConfig.EnvFile},
+	}
+}
+
+func (configFileSection ProgramConfigSection) settings() []configSetting {
+	var quotedCommand []string
+	for _, arg := range configFileSection.Command {
+		quotedCommand = append(quotedCommand, shellQuote(arg))
+	}
+
+	return []configSetting{
+		{"command", strings.Join(quotedCommand, " ")},
+		{"process_name", configFileSection.ProcessName},
+		{"numprocs", strconv.Itoa(configFileSection.NumProcs)},
+		{"numprocs_start", strconv.Itoa(configFileSection.NumProcsStart)},
+		{"priority", strconv.Itoa(configFileSection.Priority)},
+		{"nice", strconv.Itoa(configFileSection.Nice)},
+		{"autostart", strconv.FormatBool(configFileSection.AutoStart)},
+		{"startsecs", strconv.Itoa(configFileSection.StartSecs)},
+		{"startretries", strconv.Itoa(configFileSection.StartRetries)},
+		{"backoff_initial", strconv.Itoa(configFileSection.BackoffInitial)},
+		{"backoff_max", strconv.Itoa(configFileSection.BackoffMax)},
+		{"backoff_jitter", strconv.Itoa(configFileSection.BackoffJitter)},
+		{"autorestart", configFileSection.AutoRestart.String()},
+		{"exitcodes", configFileSection.ExitCodes.String()},
+		{"stopsignal", signalName(configFileSection.StopSignal)},
+		{"stopwaitsecs", strconv.Itoa(configFileSection.StopWaitSecs)},
+		{"stopasgroup", strconv.FormatBool(configFileSection.StopAsGroup)},
+		{"killasgroup", strconv.FormatBool(configFileSection.KillAsGroup)},
+		{"user", configFileSection.User},
+		{"redirect_stderr", strconv.FormatBool(configFileSection.RedirectStdErr)},
+		{"stdout_logfile", configFileSection.StdoutLogfile},
+		{"stdout_logfile_maxbytes", configFileSection.StdoutLogfileMaxbytes.String()},
+		{"stdout_logfile_backups", strconv.Itoa(configFileSection.StdoutLogfileBackups)},
+		{"stdout_capture_maxbytes", configFileSection.StdoutCaptureMaxbytes.String()},
+		{"stdout_events_enabled", strconv.FormatBool(configFileSection.StdoutEventsEnabled)},
+		{"stderr_logfile", configFileSection.StderrLogfile},
+		{"stderr_logfile_maxbytes", configFileSection.StderrLogfileMaxbytes.String()},
+		{"stderr_logfile_backups", strconv.Itoa(configFileSection.StderrLogfileBackups)},
+		{"stderr_capture_maxbytes", configFileSection.StderrCaptureMaxbytes.String()},
+		{"stderr_events_enabled", strconv.FormatBool(configFileSection.StderrEventsEnabled)},
+		{"environment", configFileSection.Environment},
+		{"env_file", configFileSection.EnvFile},
+		{"directory", configFileSection.Directory},
+		{"umask", configFileSection.Umask},
+		{"serverurl", configFileSection.ServerUrl},
+		{"depends_on", strings.Join(configFileSection.DependsOn, ",")},
+		{"readiness_probe", configFileSection.Readiness.Line},
+		{"readiness_probe_initial_delay", strconv.Itoa(configFileSection.Readiness.InitialDelay)},
+		{"readiness_probe_interval", strconv.Itoa(configFileSection.Readiness.Interval)},
+		{"readiness_probe_timeout", strconv.Itoa(configFileSection.Readiness.Timeout)},
+		{"readiness_probe_failures", strconv.Itoa(configFileSection.Readiness.Failures)},
+		{"liveness_probe", configFileSection.Liveness.Line},
+		{"liveness_probe_initial_delay", strconv.Itoa(configFileSection.Liveness.InitialDelay)},
+		{"liveness_probe_interval", strconv.Itoa(configFileSection.Liveness.Interval)},
+		{"liveness_probe_timeout", strconv.Itoa(configFileSection.Liveness.Timeout)},
+		{"liveness_probe_failures", strconv.Itoa(configFileSection.Liveness.Failures)},
+	}
+}
+
+// shellQuote quotes an argument the way a POSIX shell would need it, so the
+// printed command shows where each argument starts and ends.
+func shellQuote(arg string) string {
+	if arg == "" {
+		return "''"
+	}
+	if strings.IndexFunc(arg, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' ||
+			strings.ContainsRune("-_./:=,+@%", r))
+	}) < 0 {
+		return arg
+	}
+	return "'" + strings.Replace(arg, "'", `'"'"'`, -1) + "'"
+}
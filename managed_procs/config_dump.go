@@ -0,0 +1,134 @@
+package managed_procs
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Names that are left in place when dumping, as they differ per process
+var dumpKeepExpansions = map[string]bool{
+	"process_num": true,
+}
+
+// DumpConfig writes the config back out as one supervisord.conf, with the
+// includes, overrides and defaults applied. Values are expanded, apart from
+// %(process_num) which differs per process and %(FILE:path)s so secrets
+// don't end up in the dump, and any other % is escaped so the file can be
+// loaded again by supervisord or supervisorgo. Each program and
+// group is preceded by a comment naming the file its section came from, which
+// for overridden sections is the first file to define it.
+func (allConfig AllConfig) DumpConfig(w io.Writer, sources []string) ConfigErrors {
+	var configErrors ConfigErrors
+
+	fmt.Fprintf(w, "; Generated by supervisorgo dumpconfig from %s\n\n", strings.Join(sources, ", "))
+	dumpSection(w, "supervisord", escapeSettings(allConfig.SuperVisorD.settings()))
+	for _, name := range allConfig.groupNames() {
+		groupSection := allConfig.Groups[name]
+		fmt.Fprintf(w, "; from %s\n", groupSection.configFile)
+		dumpSection(w, "group:"+name, groupSection.settings())
+	}
+	for _, name := range allConfig.programNames() {
+		programSection := allConfig.Programs[name]
+		settings, dumpErrors := programSection.dumpSettings()
+		configErrors = append(configErrors, dumpErrors.inFile(programSection.configFile)...)
+		if eventListener, ok := allConfig.EventListeners[name]; ok {
+			settings = append(settings, escapeSettings([]configSetting{
+				{"buffer_size", eventListener.BufferSize},
+				{"events", eventListener.Events},
+				{"result_handler", eventListener.ResultHandler},
+			})...)
+		}
+		fmt.Fprintf(w, "; from %s\n", programSection.configFile)
+		dumpSection(w, programSection.sectionName, settings)
+	}
+	return configErrors
+}
+
+// dumpSettings are the settings of a program before it is split into
+// processes, with the per process keys expanded as far as they can be.
+func (configFileSection ProgramConfigSection) dumpSettings() ([]configSetting, ConfigErrors) {
+	var configErrors ConfigErrors
+	rawValues := map[string]string{
+		"command":         configFileSection.CommandLine,
+		"process_name":    configFileSection.ProcessName,
+		"directory":       configFileSection.Directory,
+		"stdout_logfile":  configFileSection.StdoutLogfile,
+		"stderr_logfile":  configFileSection.StderrLogfile,
+		"environment":     configFileSection.Environment,
+		"env_file":        configFileSection.EnvFile,
+		"user":            configFileSection.User,
+		"readiness_probe": configFileSection.Readiness.Line,
+		"liveness_probe":  configFileSection.Liveness.Line,
+	}
+
+	settings := configFileSection.settings()
+	for i, setting := range settings {
+		if !programInstanceKeys[setting.Key] {
+			settings[i].Value = restoreSecretFiles(escapeExpansion(setting.Value))
+			continue
+		}
+
+		var flattened string
+		var err error
+		expansions := configFileSection.programExpansions(setting.Key, configFileSection.NumProcsStart)
+		if setting.Key == "environment" {
+			flattened, err = flattenEnvironment(rawValues[setting.Key], expansions)
+		} else {
+			flattened, err = flattenString(rawValues[setting.Key], expansions, dumpKeepExpansions)
+		}
+		if err != nil {
+			configErrors = append(configErrors, ConfigErrors{{
+				Section: configFileSection.sectionName,
+				Key:     setting.Key,
+				Value:   rawValues[setting.Key],
+				Reason:  err.Error(),
+			}}.inFile(configFileSection.keyFile(setting.Key))...)
+			flattened = rawValues[setting.Key]
+		}
+		settings[i].Value = flattened
+	}
+	return settings, configErrors
+}
+
+func flattenEnvironment(value string, expansions map[string]interface{}) (string, error) {
+	envarmap, err := parseEnvironment(value)
+	if err != nil {
+		return "", err
+	}
+	for key, envar := range envarmap {
+		flattened, err := flattenString(envar, expansions, dumpKeepExpansions)
+		if err != nil {
+			return "", fmt.Errorf("%s: %s", key, err)
+		}
+		envarmap[key] = flattened
+	}
+	return formatEnvironment(envarmap), nil
+}
+
+func escapeExpansion(value string) string {
+	return strings.Replace(value, "%", "%%", -1)
+}
+
+// escapeSettings escapes the values, which have already been expanded, apart
+// from any secrets which go back to being read from their files.
+func escapeSettings(settings []configSetting) []configSetting {
+	for i := range settings {
+		settings[i].Value = restoreSecretFiles(escapeExpansion(settings[i].Value))
+	}
+	return settings
+}
+
+// dumpSection leaves out empty values, as supervisord treats e.g. an empty
+// user= or umask= as an error rather than as not set. Values spanning lines
+// are indented so they read back as continuation lines.
+func dumpSection(w io.Writer, sectionName string, settings []configSetting) {
+	fmt.Fprintf(w, "[%s]\n", sectionName)
+	for _, setting := range settings {
+		if setting.Value == "" {
+			continue
+		}
+		fmt.Fprintf(w, "%s = %s\n", setting.Key, strings.Replace(setting.Value, "\n", "\n    ", -1))
+	}
+	fmt.Fprintln(w)
+}
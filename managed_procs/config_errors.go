@@ -0,0 +1,170 @@
+package managed_procs
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ConfigError describes a single problem found while loading the
+// configuration. Warnings are reported but do not stop supervisorgo from
+// starting.
+type ConfigError struct {
+	File    string
+	Line    int
+	Section string
+	Key     string
+	Value   string
+	Reason  string
+	Warning bool
+}
+
+type ConfigErrors []ConfigError
+
+func (configError ConfigError) Error() string {
+	var parts []string
+
+	location := configError.File
+	if location != "" && configError.Line > 0 {
+		location = fmt.Sprintf("%s:%d", location, configError.Line)
+	}
+	if location != "" {
+		parts = append(parts, location)
+	}
+
+	if configError.Section != "" {
+		where := fmt.Sprintf("[%s]", configError.Section)
+		if configError.Key != "" {
+			where = fmt.Sprintf("%s %s=%s", where, configError.Key, configError.Value)
+		}
+		parts = append(parts, where)
+	}
+
+	reason := configError.Reason
+	if configError.Warning {
+		reason = "WARNING: " + reason
+	}
+	parts = append(parts, reason)
+	// Values are mostly shown as written, but make sure no secret slips out
+	return redactSecrets(strings.Join(parts, ": "))
+}
+
+func (configErrors ConfigErrors) Error() string {
+	var lines []string
+	for _, configError := range configErrors {
+		lines = append(lines, configError.Error())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// HasErrors is true if at least one entry is a hard error rather than a warning
+func (configErrors ConfigErrors) HasErrors() bool {
+	for _, configError := range configErrors {
+		if !configError.Warning {
+			return true
+		}
+	}
+	return false
+}
+
+// inFile fills in the file name and, where it can be found, the line number
+// of every entry that doesn't already have a location.
+func (configErrors ConfigErrors) inFile(filename string) ConfigErrors {
+	lines := NewConfigSource(filename).LineNumbers()
+	for i := range configErrors {
+		if configErrors[i].File != "" {
+			continue
+		}
+		configErrors[i].File = filename
+		if line, ok := lines[lineKey(configErrors[i].Section, configErrors[i].Key)]; ok {
+			configErrors[i].Line = line
+		} else if line, ok := lines[lineKey(configErrors[i].Section, "")]; ok {
+			configErrors[i].Line = line
+		}
+	}
+	return configErrors
+}
+
+func lineKey(section string, key string) string {
+	return section + "\x00" + key
+}
+
+// iniLineNumbers maps section headers and keys to the line they first appear
+// on. go-ini doesn't keep track of this, so the file is scanned again.
+func iniLineNumbers(filename string) map[string]int {
+	lines := make(map[string]int)
+	f, err := os.Open(filename)
+	if err != nil {
+		return lines
+	}
+	defer f.Close()
+
+	section := "DEFAULT"
+	scanner := bufio.NewScanner(f)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		if line[0] == '[' {
+			if end := strings.Index(line, "]"); end > 0 {
+				section = strings.TrimSpace(line[1:end])
+				if _, ok := lines[lineKey(section, "")]; !ok {
+					lines[lineKey(section, "")] = lineNo
+				}
+			}
+			continue
+		}
+		if end := strings.IndexAny(line, "=:"); end > 0 {
+			key := strings.TrimSpace(line[:end])
+			if _, ok := lines[lineKey(section, key)]; !ok {
+				lines[lineKey(section, key)] = lineNo
+			}
+		}
+	}
+	return lines
+}
+
+// iniParseError turns an error from go-ini into a ConfigError, finding the
+// offending line where go-ini quotes it.
+func iniParseError(filename string, err error) ConfigError {
+	configError := ConfigError{File: filename, Reason: strings.TrimSpace(err.Error())}
+	message := configError.Reason
+	idx := strings.LastIndex(message, ": ")
+	if idx < 0 {
+		return configError
+	}
+	quoted := strings.TrimSpace(message[idx+2:])
+	if quoted == "" {
+		return configError
+	}
+
+	f, openErr := os.Open(filename)
+	if openErr != nil {
+		return configError
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		if strings.TrimSpace(scanner.Text()) == quoted {
+			configError.Line = lineNo
+			break
+		}
+	}
+	return configError
+}
+
+func keyError(sectionName string, key string, value string, err error) ConfigError {
+	reason := err.Error()
+	if _, ok := err.(*strconv.NumError); ok {
+		reason = "not a valid integer"
+	}
+	return ConfigError{
+		Section: sectionName,
+		Key:     key,
+		Value:   value,
+		Reason:  reason,
+	}
+}
@@ -0,0 +1,314 @@
+package managed_procs
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/go-ini/ini.v1"
+	"gopkg.in/yaml.v2"
+)
+
+// ConfigSource is a config file in one of the supported formats. Whatever the
+// format, the config is turned into the sections a supervisord.conf would
+// have, so that they are all loaded into AllConfig the same way.
+type ConfigSource interface {
+	Sections() (*ini.File, ConfigErrors)
+	// LineNumbers maps sections and keys to where they are in the file, see
+	// lineKey. Formats that can't tell return nil.
+	LineNumbers() map[string]int
+}
+
+// NewConfigSource picks the format of a config file from its extension:
+// .json, .yaml, .yml or .toml. Anything else is read as INI.
+func NewConfigSource(file string) ConfigSource {
+	if file == ENVIRONMENT_CONFIG_SOURCE {
+		return envSource{os.Environ()}
+	}
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".json":
+		return structuredSource{file, json.Unmarshal}
+	case ".yaml", ".yml":
+		return structuredSource{file, unmarshalYAML}
+	case ".toml":
+		return structuredSource{file, toml.Unmarshal}
+	}
+	return iniSource{file}
+}
+
+type iniSource struct {
+	file string
+}
+
+func (source iniSource) Sections() (*ini.File, ConfigErrors) {
+	iniConfig, err := loadIniFile(source.file)
+	if err != nil {
+		return nil, ConfigErrors{iniParseError(source.file, err)}
+	}
+	return iniConfig, nil
+}
+
+func (source iniSource) LineNumbers() map[string]int {
+	return iniLineNumbers(source.file)
+}
+
+// structuredSource reads JSON, YAML and TOML, e.g.
+//
+//	{
+//	  "supervisord": {"nodaemon": true},
+//	  "include": {"files": ["conf.d/*.json"]},
+//	  "program": {
+//	    "web": {
+//	      "command": ["/usr/bin/web", "--port", "8080"],
+//	      "environment": {"LANG": "C.UTF-8"},
+//	      "autorestart": true
+//	    }
+//	  }
+//	}
+//
+// The program, eventlistener, group and template tables hold one table per
+// name. Any other top level table is a section of that name, so "program:web"
+// works as well. Values are the same as in INI, with a few conveniences:
+// numbers and booleans don't need quoting, command can be a list of
+// arguments, files a list of globs, environment a table, and other lists are
+// comma separated.
+type structuredSource struct {
+	file      string
+	unmarshal func([]byte, interface{}) error
+}
+
+// The top level tables that hold a table per name
+var namedSectionTypes = map[string]bool{
+	"program":       true,
+	"eventlistener": true,
+	"group":         true,
+	"template":      true,
+	"fcgi-program":  true,
+	"rpcinterface":  true,
+}
+
+func (source structuredSource) Sections() (*ini.File, ConfigErrors) {
+	data, err := ioutil.ReadFile(source.file)
+	if err != nil {
+		return nil, ConfigErrors{{File: source.file, Reason: err.Error()}}
+	}
+	var document map[string]map[string]interface{}
+	if err := source.unmarshal(data, &document); err != nil {
+		return nil, ConfigErrors{source.parseError(data, err)}
+	}
+
+	sections := make(map[string]map[string]interface{})
+	var configErrors ConfigErrors
+	addSection := func(sectionName string, value interface{}) {
+		settings, ok := plainValues(value).(map[string]interface{})
+		if !ok {
+			configErrors = append(configErrors, ConfigError{Section: sectionName, Reason: "expected a table of settings"})
+		} else if _, ok := sections[sectionName]; ok {
+			configErrors = append(configErrors, ConfigError{Section: sectionName, Reason: "section is defined more than once"})
+		} else {
+			sections[sectionName] = settings
+		}
+	}
+	for name, table := range document {
+		if !namedSectionTypes[name] {
+			addSection(name, table)
+			continue
+		}
+		for suffix, value := range table {
+			addSection(name+":"+suffix, value)
+		}
+	}
+
+	iniConfig := ini.Empty()
+	var sectionNames []string
+	for sectionName := range sections {
+		sectionNames = append(sectionNames, sectionName)
+	}
+	sort.Strings(sectionNames)
+	for _, sectionName := range sectionNames {
+		section, err := iniConfig.NewSection(sectionName)
+		if err != nil {
+			configErrors = append(configErrors, ConfigError{Section: sectionName, Reason: err.Error()})
+			continue
+		}
+		settings := sections[sectionName]
+		for _, key := range sortedKeys(settings) {
+			value, err := settingValue(key, settings[key])
+			if err == nil {
+				_, err = section.NewKey(key, value)
+			}
+			if err != nil {
+				configErrors = append(configErrors, ConfigError{Section: sectionName, Key: key, Reason: err.Error()})
+			}
+		}
+	}
+
+	for i := range configErrors {
+		configErrors[i].File = source.file
+	}
+	return iniConfig, configErrors
+}
+
+func (source structuredSource) LineNumbers() map[string]int {
+	return nil
+}
+
+func (source structuredSource) parseError(data []byte, err error) ConfigError {
+	configError := ConfigError{File: source.file, Reason: err.Error()}
+	// yaml and toml mention the line themselves, json only gives an offset
+	if syntaxError, ok := err.(*json.SyntaxError); ok {
+		configError.Line = 1 + strings.Count(string(data[:syntaxError.Offset]), "\n")
+	}
+	return configError
+}
+
+// yamlValue is a YAML value with every scalar, including the keys of tables,
+// kept as the text it was written as. Otherwise yaml would read umask: 022 as
+// the number 18, and an environment variable called Y as true.
+type yamlValue struct {
+	value interface{}
+}
+
+func (value *yamlValue) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var text string
+	if err := unmarshal(&text); err == nil {
+		value.value = text
+		return nil
+	}
+	var list []yamlValue
+	if err := unmarshal(&list); err == nil {
+		var items []interface{}
+		for _, item := range list {
+			items = append(items, item.value)
+		}
+		value.value = items
+		return nil
+	}
+	var table map[string]yamlValue
+	if err := unmarshal(&table); err != nil {
+		return err
+	}
+	settings := make(map[string]interface{})
+	for key, item := range table {
+		settings[key] = item.value
+	}
+	value.value = settings
+	return nil
+}
+
+// unmarshalYAML decodes a YAML config into the same tables as json and toml,
+// but with the values as they were written, see yamlValue.
+func unmarshalYAML(data []byte, document interface{}) error {
+	var raw map[string]map[string]yamlValue
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	tables := make(map[string]map[string]interface{})
+	for name, table := range raw {
+		tables[name] = make(map[string]interface{})
+		for key, item := range table {
+			tables[name][key] = item.value
+		}
+	}
+	*document.(*map[string]map[string]interface{}) = tables
+	return nil
+}
+
+// plainValues turns the []map[string]interface{} that toml produces for arrays
+// of tables into the []interface{} that json and yaml use.
+func plainValues(value interface{}) interface{} {
+	switch typed := value.(type) {
+	case map[string]interface{}:
+		for key, item := range typed {
+			typed[key] = plainValues(item)
+		}
+	case []interface{}:
+		for i, item := range typed {
+			typed[i] = plainValues(item)
+		}
+	case []map[string]interface{}:
+		var items []interface{}
+		for _, item := range typed {
+			items = append(items, item)
+		}
+		return items
+	}
+	return value
+}
+
+func sortedKeys(table map[string]interface{}) []string {
+	var keys []string
+	for key := range table {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// settingValue writes a value the way it would be written in a supervisord.conf
+func settingValue(key string, value interface{}) (string, error) {
+	switch typed := value.(type) {
+	case []interface{}:
+		var items []string
+		for _, item := range typed {
+			text, err := scalarValue(item)
+			if err != nil {
+				return "", err
+			}
+			if key == "command" {
+				text = shellQuote(text)
+			}
+			items = append(items, text)
+		}
+		switch key {
+		case "command", "files":
+			return strings.Join(items, " "), nil
+		}
+		return strings.Join(items, ","), nil
+	case map[string]interface{}:
+		if key != "environment" {
+			return "", fmt.Errorf("only environment can be a table")
+		}
+		envarmap := make(map[string]string)
+		for name, item := range typed {
+			text, err := scalarValue(item)
+			if err != nil {
+				return "", fmt.Errorf("%s: %s", name, err)
+			}
+			envarmap[name] = text
+		}
+		return formatEnvironment(envarmap), nil
+	}
+	return scalarValue(value)
+}
+
+func scalarValue(value interface{}) (string, error) {
+	switch typed := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return typed, nil
+	case bool:
+		return strconv.FormatBool(typed), nil
+	case int:
+		return strconv.Itoa(typed), nil
+	case int64:
+		return strconv.FormatInt(typed, 10), nil
+	case uint64:
+		return strconv.FormatUint(typed, 10), nil
+	case float64:
+		// json has no integers, so 8080 arrives as 8080.0
+		if typed == math.Trunc(typed) && math.Abs(typed) < 1e15 {
+			return strconv.FormatInt(int64(typed), 10), nil
+		}
+		return strconv.FormatFloat(typed, 'g', -1, 64), nil
+	}
+	return "", fmt.Errorf("unsupported value %v, expected a string, number or boolean", value)
+}
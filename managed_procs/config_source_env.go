@@ -0,0 +1,128 @@
+package managed_procs
+
+import (
+	"sort"
+	"strings"
+
+	"gopkg.in/go-ini/ini.v1"
+)
+
+// ENVIRONMENT_CONFIG_SOURCE stands in for a file name for the settings taken
+// from SUPERVISOR_* environment variables
+const ENVIRONMENT_CONFIG_SOURCE = "$SUPERVISOR_*"
+
+// Variables supervisord sets for its children, which are there when
+// supervisorgo is itself run by a supervisord
+var supervisorChildEnvironment = map[string]bool{
+	"SUPERVISOR_ENABLED":      true,
+	"SUPERVISOR_PROCESS_NAME": true,
+	"SUPERVISOR_GROUP_NAME":   true,
+	"SUPERVISOR_SERVER_URL":   true,
+}
+
+// envSource reads settings from environment variables such as
+//
+//	SUPERVISOR_PROGRAM_web_COMMAND=/usr/bin/web --port 8080
+//	SUPERVISOR_PROGRAM_web_AUTORESTART=true
+//	SUPERVISOR_EVENTLISTENER_fatal_EVENTS=PROCESS_STATE_FATAL
+//	SUPERVISOR_GROUP_backend_PROGRAMS=web,worker
+//	SUPERVISOR_SUPERVISORD_LOGFILE=/dev/stdout
+//
+// The name is taken as is and the key is lower cased. As both can contain
+// underscores, the key is the longest known key the variable ends with.
+type envSource struct {
+	environ []string
+}
+
+func (source envSource) Sections() (*ini.File, ConfigErrors) {
+	var configErrors ConfigErrors
+	iniConfig := ini.Empty()
+
+	sort.Strings(source.environ)
+	for _, envar := range source.environ {
+		keyval := strings.SplitN(envar, "=", 2)
+		if len(keyval) != 2 || !strings.HasPrefix(keyval[0], "SUPERVISOR_") || supervisorChildEnvironment[keyval[0]] {
+			continue
+		}
+
+		sectionName, key := envSectionKey(keyval[0])
+		if key == "" {
+			configErrors = append(configErrors, ConfigError{
+				Reason: keyval[0] + " is not of the form SUPERVISOR_PROGRAM_<name>_<KEY>, " +
+					"SUPERVISOR_EVENTLISTENER_<name>_<KEY>, SUPERVISOR_GROUP_<name>_<KEY> " +
+					"or SUPERVISOR_SUPERVISORD_<KEY> with a known KEY, ignoring",
+				Warning: true,
+			})
+			continue
+		}
+
+		section := iniConfig.Section(sectionName)
+		if _, err := section.NewKey(key, keyval[1]); err != nil {
+			configErrors = append(configErrors, ConfigError{Section: sectionName, Key: key, Value: keyval[1], Reason: err.Error()})
+		}
+	}
+
+	for i := range configErrors {
+		configErrors[i].File = ENVIRONMENT_CONFIG_SOURCE
+	}
+	return iniConfig, configErrors
+}
+
+func (source envSource) LineNumbers() map[string]int {
+	return nil
+}
+
+// envSectionKey splits a SUPERVISOR_* variable name into the section and key
+// it sets. The key is empty if it can't be worked out.
+func envSectionKey(variable string) (string, string) {
+	rest := strings.TrimPrefix(variable, "SUPERVISOR_")
+	if strings.HasPrefix(rest, "SUPERVISORD_") {
+		key := strings.ToLower(strings.TrimPrefix(rest, "SUPERVISORD_"))
+		if _, ok := knownKeys["supervisord"][key]; ok {
+			return "supervisord", key
+		}
+		return "", ""
+	}
+
+	for _, sectionKind := range []string{"program", "eventlistener", "group"} {
+		prefix := strings.ToUpper(sectionKind) + "_"
+		if !strings.HasPrefix(rest, prefix) {
+			continue
+		}
+		nameKey := strings.TrimPrefix(rest, prefix)
+		var key string
+		for known := range knownKeys[sectionKind] {
+			suffix := "_" + strings.ToUpper(known)
+			if len(known) > len(key) && strings.HasSuffix(nameKey, suffix) && len(nameKey) > len(suffix) {
+				key = known
+			}
+		}
+		if key == "" {
+			return "", ""
+		}
+		return sectionKind + ":" + nameKey[:len(nameKey)-len(key)-1], key
+	}
+	return "", ""
+}
+
+// isConfigVariable is true for the SUPERVISOR_* variables that configure
+// supervisorgo, which are not passed on to the programs.
+func isConfigVariable(variable string) bool {
+	if !strings.HasPrefix(variable, "SUPERVISOR_") || supervisorChildEnvironment[variable] {
+		return false
+	}
+	_, key := envSectionKey(variable)
+	return key != ""
+}
+
+// loadEnvironment loads the SUPERVISOR_* environment variables on top of the
+// config files. They always patch what is already defined, whether or not
+// override mode is on, so that an image can be changed with docker run -e.
+func (allConfig *AllConfig) loadEnvironment() ConfigErrors {
+	override := allConfig.override
+	allConfig.override = true
+	defer func() {
+		allConfig.override = override
+	}()
+	return allConfig.loadConfigFile(ENVIRONMENT_CONFIG_SOURCE, false)
+}
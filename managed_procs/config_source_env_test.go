@@ -0,0 +1,36 @@
+package managed_procs
+
+import "testing"
+
+func TestEnvSectionKey(t *testing.T) {
+	tests := []struct {
+		variable string
+		section  string
+		key      string
+	}{
+		{"SUPERVISOR_PROGRAM_web_COMMAND", "program:web", "command"},
+		{"SUPERVISOR_PROGRAM_my_web_AUTORESTART", "program:my_web", "autorestart"},
+		{"SUPERVISOR_PROGRAM_web_STDOUT_LOGFILE", "program:web", "stdout_logfile"},
+		{"SUPERVISOR_PROGRAM_web_STDOUT_LOGFILE_MAXBYTES", "program:web", "stdout_logfile_maxbytes"},
+		{"SUPERVISOR_PROGRAM_stdout_STDOUT_LOGFILE", "program:stdout", "stdout_logfile"},
+		{"SUPERVISOR_PROGRAM_web_USER", "program:web", "user"},
+		{"SUPERVISOR_EVENTLISTENER_fatal_EVENTS", "eventlistener:fatal", "events"},
+		{"SUPERVISOR_EVENTLISTENER_fatal_COMMAND", "eventlistener:fatal", "command"},
+		{"SUPERVISOR_GROUP_backend_PROGRAMS", "group:backend", "programs"},
+		{"SUPERVISOR_SUPERVISORD_LOGFILE", "supervisord", "logfile"},
+		{"SUPERVISOR_SUPERVISORD_LOGFILE_MAXBYTES", "supervisord", "logfile_maxbytes"},
+
+		{"SUPERVISOR_PROGRAM_COMMAND", "", ""},
+		{"SUPERVISOR_PROGRAM_web_COLOUR", "", ""},
+		{"SUPERVISOR_GROUP_backend_EVENTS", "", ""},
+		{"SUPERVISOR_SUPERVISORD_PROGRAMS", "", ""},
+		{"SUPERVISOR_SERVICE_web_COMMAND", "", ""},
+		{"SUPERVISOR_ENABLED", "", ""},
+	}
+	for _, test := range tests {
+		section, key := envSectionKey(test.variable)
+		if section != test.section || key != test.key {
+			t.Errorf("envSectionKey(%q) = %q, %q, want %q, %q", test.variable, section, key, test.section, test.key)
+		}
+	}
+}
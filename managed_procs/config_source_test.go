@@ -0,0 +1,47 @@
+package managed_procs
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestYAMLValuesAsWritten(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "supervisord.yaml")
+	contents := `
+supervisord:
+  umask: 022
+program:
+  web:
+    command: [/usr/bin/web, --port, 08080]
+    autorestart: yes
+    startsecs: 1.50
+    environment:
+      Y: on
+      EMPTY:
+`
+	if err := ioutil.WriteFile(path, []byte(contents), 0600); err != nil {
+		t.Fatal(err)
+	}
+	iniConfig, configErrors := NewConfigSource(path).Sections()
+	if configErrors != nil {
+		t.Fatalf("Sections returned errors %s", configErrors)
+	}
+
+	tests := []struct {
+		section string
+		key     string
+		want    string
+	}{
+		{"supervisord", "umask", "022"},
+		{"program:web", "command", "/usr/bin/web --port 08080"},
+		{"program:web", "autorestart", "yes"},
+		{"program:web", "startsecs", "1.50"},
+		{"program:web", "environment", `EMPTY="",Y="on"`},
+	}
+	for _, test := range tests {
+		if got := iniConfig.Section(test.section).Key(test.key).String(); got != test.want {
+			t.Errorf("[%s] %s = %q, want %q", test.section, test.key, got, test.want)
+		}
+	}
+}
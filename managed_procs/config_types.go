@@ -0,0 +1,297 @@
+package managed_procs
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// ByteSize is a size in bytes, written in the config as e.g. 1024, 100KB,
+// 50MB or 1GB. As in supervisord the multipliers are powers of 1024.
+type ByteSize int64
+
+const (
+	KILOBYTE ByteSize = 1024
+	MEGABYTE          = 1024 * KILOBYTE
+	GIGABYTE          = 1024 * MEGABYTE
+)
+
+func ParseByteSize(value string) (ByteSize, error) {
+	value = strings.TrimSpace(value)
+	multiplier := ByteSize(1)
+	number := value
+	upper := strings.ToUpper(value)
+	for suffix, suffixMultiplier := range map[string]ByteSize{"KB": KILOBYTE, "MB": MEGABYTE, "GB": GIGABYTE} {
+		if strings.HasSuffix(upper, suffix) {
+			multiplier = suffixMultiplier
+			number = strings.TrimSpace(value[:len(value)-len(suffix)])
+			break
+		}
+	}
+
+	size, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || size < 0 {
+		return 0, fmt.Errorf("not a valid byte size, expected e.g. 0, 1024, 100KB, 50MB or 1GB")
+	}
+	return ByteSize(size) * multiplier, nil
+}
+
+func (size ByteSize) String() string {
+	switch {
+	case size != 0 && size%GIGABYTE == 0:
+		return fmt.Sprintf("%dGB", size/GIGABYTE)
+	case size != 0 && size%MEGABYTE == 0:
+		return fmt.Sprintf("%dMB", size/MEGABYTE)
+	case size != 0 && size%KILOBYTE == 0:
+		return fmt.Sprintf("%dKB", size/KILOBYTE)
+	}
+	return strconv.FormatInt(int64(size), 10)
+}
+
+// AutoRestart is the autorestart= policy of a program
+type AutoRestart int
+
+const (
+	AUTORESTART_FALSE AutoRestart = iota
+	AUTORESTART_UNEXPECTED
+	AUTORESTART_TRUE
+)
+
+func ParseAutoRestart(value string) (AutoRestart, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "false":
+		return AUTORESTART_FALSE, nil
+	case "unexpected":
+		return AUTORESTART_UNEXPECTED, nil
+	case "true":
+		return AUTORESTART_TRUE, nil
+	}
+	return AUTORESTART_FALSE, fmt.Errorf("must be one of true, false or unexpected")
+}
+
+func (autoRestart AutoRestart) String() string {
+	switch autoRestart {
+	case AUTORESTART_FALSE:
+		return "false"
+	case AUTORESTART_UNEXPECTED:
+		return "unexpected"
+	case AUTORESTART_TRUE:
+		return "true"
+	}
+	return "unknown"
+}
+
+// ExitCodes are the exitcodes= a program is expected to exit with
+type ExitCodes []int
+
+func ParseExitCodes(value string) (ExitCodes, error) {
+	var exitCodes ExitCodes
+	for _, code := range strings.Split(value, ",") {
+		exitCode, err := strconv.Atoi(strings.TrimSpace(code))
+		if err != nil || exitCode < 0 || exitCode > 255 {
+			return nil, fmt.Errorf("%q is not a valid exit code, expected a comma separated list of numbers from 0 to 255", strings.TrimSpace(code))
+		}
+		if !exitCodes.Contains(exitCode) {
+			exitCodes = append(exitCodes, exitCode)
+		}
+	}
+	sort.Ints(exitCodes)
+	return exitCodes, nil
+}
+
+func (exitCodes ExitCodes) Contains(exitCode int) bool {
+	for _, code := range exitCodes {
+		if code == exitCode {
+			return true
+		}
+	}
+	return false
+}
+
+func (exitCodes ExitCodes) String() string {
+	var codes []string
+	for _, code := range exitCodes {
+		codes = append(codes, strconv.Itoa(code))
+	}
+	return strings.Join(codes, ",")
+}
+
+// The signals stopsignal= accepts by name. Any other signal can be given by
+// number.
+var signalsByName = map[string]syscall.Signal{
+	"HUP":    syscall.SIGHUP,
+	"INT":    syscall.SIGINT,
+	"QUIT":   syscall.SIGQUIT,
+	"ILL":    syscall.SIGILL,
+	"TRAP":   syscall.SIGTRAP,
+	"ABRT":   syscall.SIGABRT,
+	"BUS":    syscall.SIGBUS,
+	"FPE":    syscall.SIGFPE,
+	"KILL":   syscall.SIGKILL,
+	"USR1":   syscall.SIGUSR1,
+	"SEGV":   syscall.SIGSEGV,
+	"USR2":   syscall.SIGUSR2,
+	"PIPE":   syscall.SIGPIPE,
+	"ALRM":   syscall.SIGALRM,
+	"TERM":   syscall.SIGTERM,
+	"CHLD":   syscall.SIGCHLD,
+	"CONT":   syscall.SIGCONT,
+	"STOP":   syscall.SIGSTOP,
+	"TSTP":   syscall.SIGTSTP,
+	"TTIN":   syscall.SIGTTIN,
+	"TTOU":   syscall.SIGTTOU,
+	"URG":    syscall.SIGURG,
+	"XCPU":   syscall.SIGXCPU,
+	"XFSZ":   syscall.SIGXFSZ,
+	"VTALRM": syscall.SIGVTALRM,
+	"PROF":   syscall.SIGPROF,
+	"WINCH":  syscall.SIGWINCH,
+	"IO":     syscall.SIGIO,
+	"SYS":    syscall.SIGSYS,
+}
+
+// The highest signal number, including the real time signals
+const MAX_SIGNAL = 64
+
+// ParseSignal accepts a signal name with or without the SIG prefix, or a
+// signal number
+func ParseSignal(value string) (syscall.Signal, error) {
+	name := strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(value)), "SIG")
+	if signal, ok := signalsByName[name]; ok {
+		return signal, nil
+	}
+	if number, err := strconv.Atoi(name); err == nil && number >= 1 && number <= MAX_SIGNAL {
+		return syscall.Signal(number), nil
+	}
+	return 0, fmt.Errorf("not a valid signal, expected a name such as TERM, HUP or INT, or a number from 1 to %d", MAX_SIGNAL)
+}
+
+// signalName is the name of the signal without the SIG prefix, or its number
+// if it has no name stopsignal= accepts.
+func signalName(signal syscall.Signal) string {
+	for name, known := range signalsByName {
+		if known == signal {
+			return name
+		}
+	}
+	return strconv.Itoa(int(signal))
+}
+
+// ParseUmask accepts an octal umask, such as 022 or 0o027
+func ParseUmask(value string) (int, error) {
+	value = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(value)), "0o")
+	umask, err := strconv.ParseUint(value, 8, 32)
+	if err != nil || umask > 0777 {
+		return 0, fmt.Errorf("not a valid umask, expected an octal number such as 022")
+	}
+	return int(umask), nil
+}
+
+// ParseNice accepts a nice value from -20 (most favourable) to 19
+func ParseNice(value string) (int, error) {
+	nice, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil || nice < -20 || nice > 19 {
+		return 0, fmt.Errorf("not a valid nice value, expected a number from -20 to 19")
+	}
+	return nice, nil
+}
+
+// ProbeType is how a readiness or liveness probe checks on a process
+type ProbeType int
+
+const (
+	PROBE_NONE ProbeType = iota
+	PROBE_EXEC
+	PROBE_TCP
+	PROBE_HTTP
+)
+
+// Probe is a readiness_probe= or liveness_probe=, one of exec:<command>,
+// tcp:<port> or http:<port>/<path>. Ports are on localhost.
+type Probe struct {
+	Type    ProbeType
+	Command []string
+	Port    int
+	Path    string
+}
+
+func ParseProbe(value string) (Probe, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return Probe{Type: PROBE_NONE}, nil
+	}
+	parts := strings.SplitN(value, ":", 2)
+	if len(parts) == 2 {
+		kind, target := strings.ToLower(parts[0]), strings.TrimSpace(parts[1])
+		switch kind {
+		case "exec":
+			command, err := splitCommandLine(target)
+			if err != nil {
+				return Probe{}, err
+			}
+			if len(command) > 0 {
+				return Probe{Type: PROBE_EXEC, Command: command}, nil
+			}
+		case "tcp":
+			if port, err := parsePort(target); err == nil {
+				return Probe{Type: PROBE_TCP, Port: port}, nil
+			}
+		case "http":
+			path := "/"
+			if i := strings.Index(target, "/"); i >= 0 {
+				target, path = target[:i], target[i:]
+			}
+			if port, err := parsePort(target); err == nil {
+				return Probe{Type: PROBE_HTTP, Port: port, Path: path}, nil
+			}
+		}
+	}
+	return Probe{}, fmt.Errorf("not a valid probe, expected exec:<command>, tcp:<port> or http:<port>/<path>")
+}
+
+func parsePort(value string) (int, error) {
+	port, err := strconv.Atoi(value)
+	if err != nil || port < 1 || port > 65535 {
+		return 0, fmt.Errorf("not a valid port")
+	}
+	return port, nil
+}
+
+func (probe Probe) String() string {
+	switch probe.Type {
+	case PROBE_EXEC:
+		var quotedCommand []string
+		for _, arg := range probe.Command {
+			quotedCommand = append(quotedCommand, shellQuote(arg))
+		}
+		return "exec:" + strings.Join(quotedCommand, " ")
+	case PROBE_TCP:
+		return fmt.Sprintf("tcp:%d", probe.Port)
+	case PROBE_HTTP:
+		return fmt.Sprintf("http:%d%s", probe.Port, probe.Path)
+	}
+	return ""
+}
+
+// ProbeConfig is a probe along with how long after the process is RUNNING it
+// first runs, how often it runs after that, how long it may take and how many
+// failures in a row it takes to act on them.
+type ProbeConfig struct {
+	// As written, expanded per process into Probe
+	Line         string
+	Probe        Probe
+	InitialDelay int
+	Interval     int
+	Timeout      int
+	Failures     int
+}
+
+func defaultProbeConfig() ProbeConfig {
+	return ProbeConfig{
+		Interval: 10,
+		Timeout:  1,
+		Failures: 3,
+	}
+}
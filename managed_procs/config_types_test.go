@@ -0,0 +1,252 @@
+package managed_procs
+
+import (
+	"reflect"
+	"syscall"
+	"testing"
+)
+
+func TestParseByteSize(t *testing.T) {
+	tests := []struct {
+		value string
+		want  ByteSize
+	}{
+		{"0", 0},
+		{"1024", 1024},
+		{" 100KB ", 100 * KILOBYTE},
+		{"50MB", 50 * MEGABYTE},
+		{"50mb", 50 * MEGABYTE},
+		{"1 GB", GIGABYTE},
+	}
+	for _, test := range tests {
+		got, err := ParseByteSize(test.value)
+		if err != nil {
+			t.Errorf("ParseByteSize(%q) returned error %s", test.value, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("ParseByteSize(%q) = %d, want %d", test.value, got, test.want)
+		}
+	}
+
+	for _, value := range []string{"", "-1", "1.5MB", "10TB", "MB", "ten"} {
+		if size, err := ParseByteSize(value); err == nil {
+			t.Errorf("ParseByteSize(%q) = %d, want an error", value, size)
+		}
+	}
+}
+
+func TestByteSizeString(t *testing.T) {
+	tests := []struct {
+		size ByteSize
+		want string
+	}{
+		{0, "0"},
+		{1000, "1000"},
+		{2048, "2KB"},
+		{50 * MEGABYTE, "50MB"},
+		{GIGABYTE, "1GB"},
+	}
+	for _, test := range tests {
+		if got := test.size.String(); got != test.want {
+			t.Errorf("ByteSize(%d).String() = %q, want %q", test.size, got, test.want)
+		}
+	}
+}
+
+func TestParseAutoRestart(t *testing.T) {
+	tests := []struct {
+		value string
+		want  AutoRestart
+	}{
+		{"true", AUTORESTART_TRUE},
+		{"false", AUTORESTART_FALSE},
+		{"unexpected", AUTORESTART_UNEXPECTED},
+		{" Unexpected ", AUTORESTART_UNEXPECTED},
+		{"TRUE", AUTORESTART_TRUE},
+	}
+	for _, test := range tests {
+		got, err := ParseAutoRestart(test.value)
+		if err != nil {
+			t.Errorf("ParseAutoRestart(%q) returned error %s", test.value, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("ParseAutoRestart(%q) = %s, want %s", test.value, got, test.want)
+		}
+	}
+
+	for _, value := range []string{"", "yes", "no", "1", "0", "on", "sometimes"} {
+		_, err := ParseAutoRestart(value)
+		if err == nil {
+			t.Errorf("ParseAutoRestart(%q) returned no error, want one", value)
+			continue
+		}
+		if got, want := err.Error(), "must be one of true, false or unexpected"; got != want {
+			t.Errorf("ParseAutoRestart(%q) error = %q, want %q", value, got, want)
+		}
+	}
+}
+
+func TestParseExitCodes(t *testing.T) {
+	tests := []struct {
+		value string
+		want  ExitCodes
+	}{
+		{"0", ExitCodes{0}},
+		{"0,2", ExitCodes{0, 2}},
+		{" 2 , 0 ,2", ExitCodes{0, 2}},
+		{"255", ExitCodes{255}},
+	}
+	for _, test := range tests {
+		got, err := ParseExitCodes(test.value)
+		if err != nil {
+			t.Errorf("ParseExitCodes(%q) returned error %s", test.value, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("ParseExitCodes(%q) = %v, want %v", test.value, got, test.want)
+		}
+		if !got.Contains(test.want[0]) {
+			t.Errorf("ParseExitCodes(%q).Contains(%d) = false", test.value, test.want[0])
+		}
+	}
+
+	for _, value := range []string{"", "0,", "-1", "256", "0,two"} {
+		if exitCodes, err := ParseExitCodes(value); err == nil {
+			t.Errorf("ParseExitCodes(%q) = %v, want an error", value, exitCodes)
+		}
+	}
+}
+
+func TestParseSignal(t *testing.T) {
+	tests := []struct {
+		value string
+		want  syscall.Signal
+	}{
+		{"TERM", syscall.SIGTERM},
+		{"SIGTERM", syscall.SIGTERM},
+		{" hup ", syscall.SIGHUP},
+		{"sigkill", syscall.SIGKILL},
+		{"USR2", syscall.SIGUSR2},
+		{"STOP", syscall.SIGSTOP},
+		{"SIGWINCH", syscall.SIGWINCH},
+		{"15", syscall.SIGTERM},
+		{"34", syscall.Signal(34)},
+		{"64", syscall.Signal(64)},
+	}
+	for _, test := range tests {
+		got, err := ParseSignal(test.value)
+		if err != nil {
+			t.Errorf("ParseSignal(%q) returned error %s", test.value, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("ParseSignal(%q) = %v, want %v", test.value, got, test.want)
+		}
+		if name := signalName(got); name != signalName(test.want) {
+			t.Errorf("signalName(%v) = %q", got, name)
+		}
+	}
+
+	for _, value := range []string{"", "SIG", "0", "65", "-9", "TERMINATE"} {
+		if signal, err := ParseSignal(value); err == nil {
+			t.Errorf("ParseSignal(%q) = %v, want an error", value, signal)
+		}
+	}
+}
+
+func TestParseUmask(t *testing.T) {
+	tests := []struct {
+		value string
+		want  int
+	}{
+		{"022", 022},
+		{"22", 022},
+		{"0", 0},
+		{" 027 ", 027},
+		{"0o077", 077},
+		{"0O002", 002},
+		{"777", 0777},
+	}
+	for _, test := range tests {
+		got, err := ParseUmask(test.value)
+		if err != nil {
+			t.Errorf("ParseUmask(%q) returned error %s", test.value, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("ParseUmask(%q) = %#o, want %#o", test.value, got, test.want)
+		}
+	}
+
+	for _, value := range []string{"", "08", "1000", "-1", "0x12", "rwx"} {
+		if umask, err := ParseUmask(value); err == nil {
+			t.Errorf("ParseUmask(%q) = %#o, want an error", value, umask)
+		}
+	}
+}
+
+func TestParseNice(t *testing.T) {
+	tests := []struct {
+		value string
+		want  int
+	}{
+		{"0", 0},
+		{"10", 10},
+		{" -5 ", -5},
+		{"-20", -20},
+		{"19", 19},
+	}
+	for _, test := range tests {
+		got, err := ParseNice(test.value)
+		if err != nil {
+			t.Errorf("ParseNice(%q) returned error %s", test.value, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("ParseNice(%q) = %d, want %d", test.value, got, test.want)
+		}
+	}
+
+	for _, value := range []string{"", "-21", "20", "1.5", "low"} {
+		if nice, err := ParseNice(value); err == nil {
+			t.Errorf("ParseNice(%q) = %d, want an error", value, nice)
+		}
+	}
+}
+
+func TestParseProbe(t *testing.T) {
+	tests := []struct {
+		value     string
+		want      Probe
+		formatted string
+	}{
+		{"", Probe{Type: PROBE_NONE}, ""},
+		{"exec:/bin/check --quick", Probe{Type: PROBE_EXEC, Command: []string{"/bin/check", "--quick"}}, "exec:/bin/check --quick"},
+		{"exec: pg_isready -h 'local host'", Probe{Type: PROBE_EXEC, Command: []string{"pg_isready", "-h", "local host"}}, "exec:pg_isready -h 'local host'"},
+		{"tcp:8080", Probe{Type: PROBE_TCP, Port: 8080}, "tcp:8080"},
+		{"TCP: 5432 ", Probe{Type: PROBE_TCP, Port: 5432}, "tcp:5432"},
+		{"http:8080", Probe{Type: PROBE_HTTP, Port: 8080, Path: "/"}, "http:8080/"},
+		{"http:8080/health?full=1", Probe{Type: PROBE_HTTP, Port: 8080, Path: "/health?full=1"}, "http:8080/health?full=1"},
+	}
+	for _, test := range tests {
+		got, err := ParseProbe(test.value)
+		if err != nil {
+			t.Errorf("ParseProbe(%q) returned error %s", test.value, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("ParseProbe(%q) = %+v, want %+v", test.value, got, test.want)
+		}
+		if got.String() != test.formatted {
+			t.Errorf("ParseProbe(%q).String() = %q, want %q", test.value, got.String(), test.formatted)
+		}
+	}
+
+	for _, value := range []string{"8080", "exec:", "exec:'unterminated", "tcp:", "tcp:0", "tcp:65536", "tcp:http", "http:/health", "udp:53"} {
+		if probe, err := ParseProbe(value); err == nil {
+			t.Errorf("ParseProbe(%q) = %+v, want an error", value, probe)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package managed_procs
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// ResolveDependencies checks that every program named in a depends_on= exists
+// and that no program ends up depending on itself.
+func (allConfig *AllConfig) ResolveDependencies() ConfigErrors {
+	var configErrors ConfigErrors
+	for _, name := range allConfig.programNames() {
+		programSection := allConfig.Programs[name]
+		var dependencyErrors ConfigErrors
+		for _, dependency := range programSection.DependsOn {
+			if _, ok := allConfig.Programs[dependency]; !ok {
+				dependencyErrors = append(dependencyErrors, ConfigError{
+					Section: programSection.sectionName,
+					Key:     "depends_on",
+					Value:   dependency,
+					Reason:  "no such program",
+				})
+			}
+		}
+		configErrors = append(configErrors, dependencyErrors.inFile(programSection.keyFile("depends_on"))...)
+	}
+
+	// Depth first, a program that is reached again while its own dependencies
+	// are still being followed is part of a cycle
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[string]int)
+	var path []string
+	var visit func(name string)
+	visit = func(name string) {
+		state[name] = visiting
+		path = append(path, name)
+		programSection := allConfig.Programs[name]
+		for _, dependency := range programSection.DependsOn {
+			if _, ok := allConfig.Programs[dependency]; !ok {
+				continue
+			}
+			switch state[dependency] {
+			case unvisited:
+				visit(dependency)
+			case visiting:
+				var cycle []string
+				for i := len(path) - 1; i >= 0; i-- {
+					if path[i] == dependency {
+						cycle = append(append(cycle, path[i:]...), dependency)
+						break
+					}
+				}
+				configErrors = append(configErrors, ConfigErrors{{
+					Section: programSection.sectionName,
+					Key:     "depends_on",
+					Value:   dependency,
+					Reason:  fmt.Sprintf("dependency cycle: %s", strings.Join(cycle, " -> ")),
+				}}.inFile(programSection.keyFile("depends_on"))...)
+			}
+		}
+		path = path[:len(path)-1]
+		state[name] = visited
+	}
+	for _, name := range allConfig.programNames() {
+		if state[name] == unvisited {
+			visit(name)
+		}
+	}
+	return configErrors
+}
+
+// orderByDependencies keeps the programs in the order they are already in,
+// apart from moving each one after those it depends on, so that starting
+// them in order and stopping them in reverse brings dependencies up first and
+// takes them down last. It also links each program to its dependencies and
+// the other way round.
+func (runningData *RunningData) orderByDependencies() {
+	byName := make(map[string][]*Program)
+	for _, program := range runningData.programs {
+		byName[program.config.programName] = append(byName[program.config.programName], program)
+	}
+	for _, program := range runningData.programs {
+		program.dependencies = nil
+		program.dependents = nil
+	}
+	for _, program := range runningData.programs {
+		for _, dependency := range program.config.DependsOn {
+			program.dependencies = append(program.dependencies, byName[dependency]...)
+			for _, dependencyProgram := range byName[dependency] {
+				dependencyProgram.dependents = append(dependencyProgram.dependents, program)
+			}
+		}
+	}
+
+	var ordered []*Program
+	placed := make(map[*Program]bool)
+	for len(ordered) < len(runningData.programs) {
+		progress := false
+		for _, program := range runningData.programs {
+			if placed[program] || !allPlaced(program.dependencies, placed) {
+				continue
+			}
+			ordered = append(ordered, program)
+			placed[program] = true
+			progress = true
+			// Start again from the top, so that priority order is kept
+			// as far as the dependencies allow
+			break
+		}
+		if !progress {
+			// Cycles are config errors, so this can't happen, but don't hang
+			for _, program := range runningData.programs {
+				if !placed[program] {
+					ordered = append(ordered, program)
+				}
+			}
+			break
+		}
+	}
+	runningData.programs = ordered
+}
+
+func allPlaced(programs []*Program, placed map[*Program]bool) bool {
+	for _, program := range programs {
+		if !placed[program] {
+			return false
+		}
+	}
+	return true
+}
+
+// isUp is whether the programs depending on this one can be started: it is
+// RUNNING and, if it has a readiness probe, ready.
+func (program *Program) isUp() bool {
+	if program.config.Readiness.Probe.Type != PROBE_NONE && !program.ready {
+		return false
+	}
+	return program.programStatus == PROC_RUNNING
+}
+
+// unmetDependencies are the names of the processes the program is still
+// waiting for
+func (program *Program) unmetDependencies() []string {
+	var waiting []string
+	for _, dependency := range program.dependencies {
+		if !dependency.isUp() {
+			waiting = append(waiting, dependency.Name())
+		}
+	}
+	return waiting
+}
+
+// startWaitingProcesses starts the processes whose dependencies have come up
+func (runningData *RunningData) startWaitingProcesses() {
+	for _, program := range runningData.programs {
+		if program.waitingForDependencies && program.programStatus == PROC_STOPPED {
+			program.StartRunableProcess()
+		}
+	}
+}
+
+// waitForDependencies reports whether the program has to wait before it can
+// be started, logging what it waits for the first time.
+func (program *Program) waitForDependencies() bool {
+	waiting := program.unmetDependencies()
+	if len(waiting) == 0 {
+		program.waitingForDependencies = false
+		return false
+	}
+	if !program.waitingForDependencies {
+		log.Printf("%s is waiting for %s to start\n", program.Name(), strings.Join(waiting, ", "))
+	}
+	program.waitingForDependencies = true
+	return true
+}
@@ -0,0 +1,116 @@
+package managed_procs
+
+import (
+	"sort"
+	"strings"
+	"testing"
+)
+
+// testDependencyConfig has a program per entry, depending on the programs
+// listed for it
+func testDependencyConfig(dependencies map[string][]string) AllConfig {
+	allConfig := AllConfig{Programs: make(map[string]ProgramConfigSection)}
+	for name, dependsOn := range dependencies {
+		programSection := GetDefaultProgramSection(name)
+		programSection.DependsOn = dependsOn
+		allConfig.Programs[name] = programSection
+	}
+	return allConfig
+}
+
+func TestResolveDependencies(t *testing.T) {
+	tests := []struct {
+		name         string
+		dependencies map[string][]string
+		want         []string
+	}{
+		{
+			name:         "no dependencies",
+			dependencies: map[string][]string{"a": nil, "b": nil},
+		},
+		{
+			name:         "chain",
+			dependencies: map[string][]string{"web": {"app"}, "app": {"db", "cache"}, "db": nil, "cache": nil},
+		},
+		{
+			name:         "diamond",
+			dependencies: map[string][]string{"a": {"b", "c"}, "b": {"d"}, "c": {"d"}, "d": nil},
+		},
+		{
+			name:         "unknown program",
+			dependencies: map[string][]string{"web": {"db"}},
+			want:         []string{"[program:web] depends_on=db: no such program"},
+		},
+		{
+			name:         "depends on itself",
+			dependencies: map[string][]string{"a": {"a"}},
+			want:         []string{"[program:a] depends_on=a: dependency cycle: a -> a"},
+		},
+		{
+			name:         "cycle",
+			dependencies: map[string][]string{"a": {"b"}, "b": {"c"}, "c": {"a"}},
+			want:         []string{"[program:c] depends_on=a: dependency cycle: a -> b -> c -> a"},
+		},
+		{
+			name:         "cycle below a program that isn't in it",
+			dependencies: map[string][]string{"a": {"b"}, "b": {"c"}, "c": {"b"}},
+			want:         []string{"[program:c] depends_on=b: dependency cycle: b -> c -> b"},
+		},
+	}
+	for _, test := range tests {
+		allConfig := testDependencyConfig(test.dependencies)
+		var got []string
+		for _, configError := range allConfig.ResolveDependencies() {
+			configError.File = ""
+			got = append(got, configError.Error())
+		}
+		sort.Strings(got)
+		if strings.Join(got, "\n") != strings.Join(test.want, "\n") {
+			t.Errorf("%s: ResolveDependencies() = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestOrderByDependencies(t *testing.T) {
+	tests := []struct {
+		name         string
+		order        []string
+		dependencies map[string][]string
+		want         string
+	}{
+		{
+			name:  "priority order is kept without dependencies",
+			order: []string{"c", "a", "b"},
+			want:  "c a b",
+		},
+		{
+			name:         "dependencies move ahead",
+			order:        []string{"web", "app", "db"},
+			dependencies: map[string][]string{"web": {"app"}, "app": {"db"}},
+			want:         "db app web",
+		},
+		{
+			name:         "only as far as needed",
+			order:        []string{"web", "other", "db", "last"},
+			dependencies: map[string][]string{"web": {"db"}},
+			want:         "other db web last",
+		},
+	}
+	for _, test := range tests {
+		runningData := RunningData{}
+		for _, name := range test.order {
+			programSection := GetDefaultProgramSection(name)
+			programSection.DependsOn = test.dependencies[name]
+			runningData.programs = append(runningData.programs, &Program{config: programSection})
+		}
+		runningData.orderByDependencies()
+
+		var got []string
+		for _, program := range runningData.programs {
+			got = append(got, program.config.programName)
+		}
+		if strings.Join(got, " ") != test.want {
+			t.Errorf("%s: orderByDependencies() = %s, want %s", test.name, strings.Join(got, " "), test.want)
+		}
+	}
+}
@@ -0,0 +1,249 @@
+package managed_procs
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+// parseEnvironment parses an environment= value such as
+//
+//	KEY="value",KEY2='C:\dir',JDBC=jdbc:mysql://db/x?a=1&b=2,OPTS="-Xmx1g,-Xms1g"
+//
+// Values may be double quoted, single quoted or bare. Bare values run up to
+// the next comma and may contain '=', double quoted values may contain commas
+// and backslash escapes such as \" and \\, and single quoted values are taken
+// literally. A backslash outside of quotes escapes the next character, so a
+// bare value can contain a comma written as \,.
+func parseEnvironment(value string) (map[string]string, error) {
+	envarmap := make(map[string]string)
+	runes := []rune(value)
+	i := 0
+
+	skipSpace := func() {
+		for i < len(runes) && strings.ContainsRune(" \t\r\n", runes[i]) {
+			i++
+		}
+	}
+
+	for {
+		skipSpace()
+		if i >= len(runes) {
+			break
+		}
+
+		keyStart := i
+		for i < len(runes) && !strings.ContainsRune("=,\"' \t\r\n", runes[i]) {
+			i++
+		}
+		key := string(runes[keyStart:i])
+		skipSpace()
+		if key == "" {
+			return nil, fmt.Errorf("expected a variable name at %q", string(runes[keyStart:]))
+		}
+		if i >= len(runes) || runes[i] != '=' {
+			return nil, fmt.Errorf("expected '=' after %s", key)
+		}
+		i++
+		skipSpace()
+
+		var val strings.Builder
+		// Whitespace before the comma is dropped, unless it was quoted
+		quotedEnd := 0
+	valueLoop:
+		for i < len(runes) {
+			switch runes[i] {
+			case ',':
+				break valueLoop
+			case '\\':
+				i++
+				if i >= len(runes) {
+					return nil, errors.New("no escaped character after trailing backslash")
+				}
+				val.WriteRune(runes[i])
+				i++
+			case '"':
+				for i++; i < len(runes) && runes[i] != '"'; i++ {
+					if runes[i] == '\\' && i+1 < len(runes) {
+						i++
+					}
+					val.WriteRune(runes[i])
+				}
+				if i >= len(runes) {
+					return nil, fmt.Errorf("no closing double quotation in value of %s", key)
+				}
+				quotedEnd = val.Len()
+				i++
+			case '\'':
+				end := i + 1
+				for end < len(runes) && runes[end] != '\'' {
+					end++
+				}
+				if end >= len(runes) {
+					return nil, fmt.Errorf("no closing single quotation in value of %s", key)
+				}
+				val.WriteString(string(runes[i+1 : end]))
+				quotedEnd = val.Len()
+				i = end + 1
+			default:
+				val.WriteRune(runes[i])
+				i++
+			}
+		}
+
+		envar := val.String()
+		envarmap[key] = envar[:quotedEnd] + strings.TrimRight(envar[quotedEnd:], " \t\r\n")
+
+		if i < len(runes) {
+			// Skip the comma
+			i++
+		}
+	}
+	return envarmap, nil
+}
+
+// formatEnvironment is the reverse of parseEnvironment, with the variables
+// sorted by name.
+func formatEnvironment(envarmap map[string]string) string {
+	var keys []string
+	for key := range envarmap {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	escaper := strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+	var envars []string
+	for _, key := range keys {
+		envars = append(envars, fmt.Sprintf(`%s="%s"`, key, escaper.Replace(envarmap[key])))
+	}
+	return strings.Join(envars, ",")
+}
+
+// expandEnvironment expands each value of an environment= setting on its
+// own, so that what a %(ENV_X)s expands to can't break the parsing.
+func expandEnvironment(value string, expansions map[string]interface{}) (string, error) {
+	envarmap, err := parseEnvironment(value)
+	if err != nil {
+		return "", err
+	}
+	for key, envar := range envarmap {
+		expanded, err := expandString(envar, expansions)
+		if err != nil {
+			return "", fmt.Errorf("%s: %s", key, err)
+		}
+		envarmap[key] = expanded
+	}
+	return formatEnvironment(envarmap), nil
+}
+
+// splitEnvFiles splits an env_file= value, which can list several files
+// separated by commas.
+func splitEnvFiles(value string) []string {
+	var envFiles []string
+	for _, envFile := range strings.Split(value, ",") {
+		envFile = strings.TrimSpace(envFile)
+		if envFile != "" {
+			envFiles = append(envFiles, envFile)
+		}
+	}
+	return envFiles
+}
+
+// parseEnvFile reads a dotenv file of KEY=value lines, optionally starting
+// with "export". Blank lines and lines starting with # are ignored. Values
+// may be double quoted, with \n, \t, \" and \\ escapes and spanning lines,
+// single quoted to be taken literally, or bare, in which case a # after
+// whitespace starts a comment. Nothing is expanded.
+func parseEnvFile(path string) (map[string]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	envarmap := make(map[string]string)
+	lines := strings.Split(strings.Replace(string(data), "\r\n", "\n", -1), "\n")
+	for i := 0; i < len(lines); i++ {
+		lineNo := i + 1
+		line := strings.TrimSpace(lines[i])
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		if strings.HasPrefix(line, "export ") {
+			line = strings.TrimSpace(strings.TrimPrefix(line, "export "))
+		}
+
+		equals := strings.Index(line, "=")
+		key := ""
+		if equals > 0 {
+			key = strings.TrimSpace(line[:equals])
+		}
+		if key == "" || strings.ContainsAny(key, " \t\"'") {
+			return nil, fmt.Errorf("%s:%d: expected KEY=value", path, lineNo)
+		}
+		value := strings.TrimSpace(line[equals+1:])
+
+		var rest string
+		switch {
+		case strings.HasPrefix(value, "\""):
+			var unquoted strings.Builder
+			text := value[1:]
+			closed := false
+			for !closed {
+				for j := 0; j < len(text); j++ {
+					if text[j] == '\\' && j+1 < len(text) {
+						j++
+						switch text[j] {
+						case 'n':
+							unquoted.WriteByte('\n')
+						case 't':
+							unquoted.WriteByte('\t')
+						case 'r':
+							unquoted.WriteByte('\r')
+						case '"', '\\', '$':
+							unquoted.WriteByte(text[j])
+						default:
+							unquoted.WriteByte('\\')
+							unquoted.WriteByte(text[j])
+						}
+					} else if text[j] == '"' {
+						rest = text[j+1:]
+						closed = true
+						break
+					} else {
+						unquoted.WriteByte(text[j])
+					}
+				}
+				if !closed {
+					// The value carries on on the next line
+					i++
+					if i >= len(lines) {
+						return nil, fmt.Errorf("%s:%d: no closing double quotation in value of %s", path, lineNo, key)
+					}
+					unquoted.WriteByte('\n')
+					text = lines[i]
+				}
+			}
+			value = unquoted.String()
+		case strings.HasPrefix(value, "'"):
+			end := strings.Index(value[1:], "'")
+			if end < 0 {
+				return nil, fmt.Errorf("%s:%d: no closing single quotation in value of %s", path, lineNo, key)
+			}
+			rest = value[end+2:]
+			value = value[1 : end+1]
+		default:
+			if comment := strings.Index(value, " #"); comment >= 0 {
+				value = strings.TrimSpace(value[:comment])
+			}
+		}
+
+		rest = strings.TrimSpace(rest)
+		if rest != "" && rest[0] != '#' {
+			return nil, fmt.Errorf("%s:%d: unexpected %q after the quoted value of %s", path, lineNo, rest, key)
+		}
+		envarmap[key] = value
+	}
+	return envarmap, nil
+}
@@ -0,0 +1,188 @@
+package managed_procs
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseEnvironment(t *testing.T) {
+	tests := []struct {
+		value string
+		want  map[string]string
+	}{
+		{"", map[string]string{}},
+		{"A=1", map[string]string{"A": "1"}},
+		{"A=1,B=2", map[string]string{"A": "1", "B": "2"}},
+		{" A = 1 , B = 2 ", map[string]string{"A": "1", "B": "2"}},
+		{"A=1,", map[string]string{"A": "1"}},
+		{"A=", map[string]string{"A": ""}},
+		{`A=""`, map[string]string{"A": ""}},
+		{`A="x,y",B=z`, map[string]string{"A": "x,y", "B": "z"}},
+		{`A=" padded "`, map[string]string{"A": " padded "}},
+		{`A="say \"hi\"",B="C:\\dir"`, map[string]string{"A": `say "hi"`, "B": `C:\dir`}},
+		{`A='C:\dir',B='x,"y"'`, map[string]string{"A": `C:\dir`, "B": `x,"y"`}},
+		{`A=x\,y`, map[string]string{"A": "x,y"}},
+		{"JDBC=jdbc:mysql://db/x?a=1&b=2", map[string]string{"JDBC": "jdbc:mysql://db/x?a=1&b=2"}},
+		{`OPTS="-Xmx1g,-Xms1g",LANG=C.UTF-8`, map[string]string{"OPTS": "-Xmx1g,-Xms1g", "LANG": "C.UTF-8"}},
+		{`A=pre"quoted"post`, map[string]string{"A": "prequotedpost"}},
+		{"A=1,A=2", map[string]string{"A": "2"}},
+		{"A=1,\n  B=2", map[string]string{"A": "1", "B": "2"}},
+	}
+	for _, test := range tests {
+		got, err := parseEnvironment(test.value)
+		if err != nil {
+			t.Errorf("parseEnvironment(%q) returned error %s", test.value, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("parseEnvironment(%q) = %q, want %q", test.value, got, test.want)
+		}
+	}
+}
+
+func TestParseEnvironmentErrors(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"=1", "expected a variable name"},
+		{"A", "expected '=' after A"},
+		{"A 1", "expected '=' after A"},
+		{"A=1,B", "expected '=' after B"},
+		{`A="x`, "no closing double quotation in value of A"},
+		{`A='x`, "no closing single quotation in value of A"},
+		{`A=x\`, "no escaped character after trailing backslash"},
+	}
+	for _, test := range tests {
+		got, err := parseEnvironment(test.value)
+		if err == nil {
+			t.Errorf("parseEnvironment(%q) = %q, want error containing %q", test.value, got, test.want)
+			continue
+		}
+		if !strings.Contains(err.Error(), test.want) {
+			t.Errorf("parseEnvironment(%q) returned error %q, want it to contain %q", test.value, err, test.want)
+		}
+	}
+}
+
+func TestFormatEnvironmentRoundTrip(t *testing.T) {
+	envarmap := map[string]string{
+		"B":     `say "hi"`,
+		"A":     `C:\dir`,
+		"OPTS":  "-Xmx1g,-Xms1g",
+		"EMPTY": "",
+		"PCT":   "100%",
+	}
+	formatted := formatEnvironment(envarmap)
+	if want := `A="C:\\dir",B="say \"hi\"",EMPTY="",OPTS="-Xmx1g,-Xms1g",PCT="100%"`; formatted != want {
+		t.Errorf("formatEnvironment() = %s, want %s", formatted, want)
+	}
+	parsed, err := parseEnvironment(formatted)
+	if err != nil {
+		t.Fatalf("parseEnvironment(%q) returned error %s", formatted, err)
+	}
+	if !reflect.DeepEqual(parsed, envarmap) {
+		t.Errorf("parseEnvironment(formatEnvironment(%q)) = %q", envarmap, parsed)
+	}
+}
+
+func TestExpandEnvironment(t *testing.T) {
+	expansions := map[string]interface{}{
+		"program_name": "web",
+		"ENV_QUOTED":   `a "b", c`,
+	}
+	got, err := expandEnvironment(`NAME=%(program_name)s,Q=%(ENV_QUOTED)s,PCT="100%%"`, expansions)
+	if err != nil {
+		t.Fatalf("expandEnvironment returned error %s", err)
+	}
+	if want := `NAME="web",PCT="100%",Q="a \"b\", c"`; got != want {
+		t.Errorf("expandEnvironment() = %s, want %s", got, want)
+	}
+
+	if _, err := expandEnvironment("A=%(nope)s", expansions); err == nil || !strings.HasPrefix(err.Error(), "A: ") {
+		t.Errorf("expandEnvironment with an undefined name returned %v, want an error for A", err)
+	}
+}
+
+func TestSplitEnvFiles(t *testing.T) {
+	tests := []struct {
+		value string
+		want  []string
+	}{
+		{"", nil},
+		{"/a.env", []string{"/a.env"}},
+		{" /a.env , /b.env ,", []string{"/a.env", "/b.env"}},
+	}
+	for _, test := range tests {
+		if got := splitEnvFiles(test.value); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("splitEnvFiles(%q) = %q, want %q", test.value, got, test.want)
+		}
+	}
+}
+
+func writeEnvFile(t *testing.T, contents string) string {
+	path := filepath.Join(t.TempDir(), "test.env")
+	if err := ioutil.WriteFile(path, []byte(contents), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestParseEnvFile(t *testing.T) {
+	tests := []struct {
+		contents string
+		want     map[string]string
+	}{
+		{"", map[string]string{}},
+		{"# comment\n\nA=1\n", map[string]string{"A": "1"}},
+		{"export A=1\r\nB = 2", map[string]string{"A": "1", "B": "2"}},
+		{"A=x # comment\nB=x#not-a-comment", map[string]string{"A": "x", "B": "x#not-a-comment"}},
+		{"A=a=b", map[string]string{"A": "a=b"}},
+		{`A="x # y" # comment`, map[string]string{"A": "x # y"}},
+		{`A="line\nnext\t\"q\" \\ \$ \x"`, map[string]string{"A": "line\nnext\t\"q\" \\ $ \\x"}},
+		{"A=\"first\nsecond\"\nB=3", map[string]string{"A": "first\nsecond", "B": "3"}},
+		{`A='$HOME \n "x"'`, map[string]string{"A": `$HOME \n "x"`}},
+		{"A=", map[string]string{"A": ""}},
+	}
+	for _, test := range tests {
+		got, err := parseEnvFile(writeEnvFile(t, test.contents))
+		if err != nil {
+			t.Errorf("parseEnvFile(%q) returned error %s", test.contents, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("parseEnvFile(%q) = %q, want %q", test.contents, got, test.want)
+		}
+	}
+}
+
+func TestParseEnvFileErrors(t *testing.T) {
+	tests := []struct {
+		contents string
+		want     string
+	}{
+		{"JUST_A_NAME", ":1: expected KEY=value"},
+		{"A=1\n=2", ":2: expected KEY=value"},
+		{"MY KEY=1", ":1: expected KEY=value"},
+		{"A=\"open\nstill open", ":1: no closing double quotation in value of A"},
+		{"A='open", ":1: no closing single quotation in value of A"},
+		{`A="x" y`, `:1: unexpected "y" after the quoted value of A`},
+	}
+	for _, test := range tests {
+		got, err := parseEnvFile(writeEnvFile(t, test.contents))
+		if err == nil {
+			t.Errorf("parseEnvFile(%q) = %q, want error containing %q", test.contents, got, test.want)
+			continue
+		}
+		if !strings.Contains(err.Error(), test.want) {
+			t.Errorf("parseEnvFile(%q) returned error %q, want it to contain %q", test.contents, err, test.want)
+		}
+	}
+
+	if _, err := parseEnvFile(filepath.Join(t.TempDir(), "missing.env")); err == nil {
+		t.Error("parseEnvFile of a missing file returned no error")
+	}
+}
@@ -0,0 +1,367 @@
+package managed_procs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/go-ini/ini.v1"
+)
+
+// Program keys that are expanded per process instance, so they can make use
+// of %(program_name)s, %(process_num)d and friends. Every other key is
+// expanded as it is loaded, with only the names from baseExpansions.
+var programInstanceKeys = map[string]bool{
+	"command":         true,
+	"process_name":    true,
+	"directory":       true,
+	"stdout_logfile":  true,
+	"stderr_logfile":  true,
+	"environment":     true,
+	"env_file":        true,
+	"user":            true,
+	"readiness_probe": true,
+	"liveness_probe":  true,
+}
+
+// baseExpansions are the names that can be used in any key: %(here)s,
+// %(host_node_name)s and %(ENV_X)s for every environment variable X.
+func baseExpansions(configFile string) map[string]interface{} {
+	expansions := make(map[string]interface{})
+	for _, envar := range os.Environ() {
+		keyval := strings.SplitN(envar, "=", 2)
+		if len(keyval) == 2 {
+			expansions["ENV_"+keyval[0]] = keyval[1]
+		}
+	}
+	if configFile != "" && configFile != ENVIRONMENT_CONFIG_SOURCE {
+		here, err := filepath.Abs(filepath.Dir(configFile))
+		if err != nil {
+			here = filepath.Dir(configFile)
+		}
+		expansions["here"] = here
+	}
+	if hostname, err := os.Hostname(); err == nil {
+		expansions["host_node_name"] = hostname
+	}
+	return expansions
+}
+
+// expandSectionValues expands every key of the section in place, apart from
+// the ones skip says will be dealt with later.
+func expandSectionValues(section *ini.Section, expansions map[string]interface{}, skip map[string]bool) ConfigErrors {
+	var configErrors ConfigErrors
+	for _, key := range section.KeyStrings() {
+		if skip[key] {
+			continue
+		}
+		value := section.Key(key).Value()
+		var expanded string
+		var err error
+		if key == "environment" {
+			expanded, err = expandEnvironment(value, expansions)
+		} else {
+			expanded, err = expandString(value, expansions)
+		}
+		if err != nil {
+			configErrors = append(configErrors, ConfigError{
+				Section: section.Name(),
+				Key:     key,
+				Value:   value,
+				Reason:  err.Error(),
+			})
+			continue
+		}
+		section.Key(key).SetValue(expanded)
+	}
+	return configErrors
+}
+
+// expandString implements the python %-formatting that supervisord applies to
+// config values, e.g. %(ENV_HOME)s or %(process_num)02d. A literal percent
+// sign has to be written as %%. On top of what supervisord does,
+// %(FILE:/run/secrets/x)s is replaced by the contents of the file, and the
+// path can itself use expansions, e.g. %(FILE:%(here)s/secret)s.
+func expandString(value string, expansions map[string]interface{}) (string, error) {
+	if !strings.Contains(value, "%") {
+		return value, nil
+	}
+	return formatString(value, func(text string) string {
+		return text
+	}, func(name string, spec string, conversion byte, directive string) (string, error) {
+		if strings.HasPrefix(name, "FILE:") {
+			path, err := expandString(strings.TrimPrefix(name, "FILE:"), expansions)
+			if err != nil {
+				return "", err
+			}
+			secret, err := readSecretFile(path)
+			if err != nil {
+				return "", err
+			}
+			return formatExpansion(name, secret, spec, conversion)
+		}
+		replacement, ok := expansions[name]
+		if !ok {
+			return "", undefinedNameError(name, expansions)
+		}
+		return formatExpansion(name, replacement, spec, conversion)
+	})
+}
+
+// flattenString expands a value like expandString does, apart from the names
+// in keep and secrets read with %(FILE:path)s which are left as they are, and
+// escapes the result so that it still means the same when it is expanded
+// again.
+func flattenString(value string, expansions map[string]interface{}, keep map[string]bool) (string, error) {
+	escape := func(text string) string {
+		return strings.Replace(text, "%", "%%", -1)
+	}
+	return formatString(value, escape, func(name string, spec string, conversion byte, directive string) (string, error) {
+		if keep[name] {
+			return directive, nil
+		}
+		if strings.HasPrefix(name, "FILE:") {
+			path, err := flattenString(strings.TrimPrefix(name, "FILE:"), expansions, keep)
+			return "%(FILE:" + path + ")" + spec + string(conversion), err
+		}
+		replacement, ok := expansions[name]
+		if !ok {
+			return "", undefinedNameError(name, expansions)
+		}
+		formatted, err := formatExpansion(name, replacement, spec, conversion)
+		return escape(formatted), err
+	})
+}
+
+// formatString parses a %-format string, passing the literal text and each
+// %(name)s directive to the given functions to build the result.
+func formatString(value string,
+	literal func(text string) string,
+	directive func(name string, spec string, conversion byte, directive string) (string, error)) (string, error) {
+
+	var expanded strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] != '%' {
+			expanded.WriteString(literal(value[i : i+1]))
+			continue
+		}
+
+		directiveStart := i
+		i++
+		if i >= len(value) {
+			return "", fmt.Errorf("incomplete format, a single %% must be written as %%%%")
+		}
+		if value[i] == '%' {
+			expanded.WriteString(literal("%"))
+			continue
+		}
+		if value[i] != '(' {
+			return "", fmt.Errorf("bad format at %q, expected %%(name)s or %%%%", value[i-1:])
+		}
+
+		// Python allows nested parentheses in the name
+		start := i + 1
+		depth := 1
+		for i++; i < len(value) && depth > 0; i++ {
+			if value[i] == '(' {
+				depth++
+			} else if value[i] == ')' {
+				depth--
+			}
+		}
+		if depth > 0 {
+			return "", fmt.Errorf("incomplete format key in %q", value[start-2:])
+		}
+		name := value[start : i-1]
+
+		specStart := i
+		for i < len(value) && strings.IndexByte("#0- +", value[i]) >= 0 {
+			i++
+		}
+		for i < len(value) && (value[i] >= '0' && value[i] <= '9' || value[i] == '.') {
+			i++
+		}
+		for i < len(value) && strings.IndexByte("hlL", value[i]) >= 0 {
+			i++
+		}
+		if i >= len(value) {
+			return "", fmt.Errorf("incomplete format %q, missing conversion type such as 's' or 'd'", value[start-2:])
+		}
+		spec := value[specStart:i]
+		spec = strings.TrimRight(spec, "hlL")
+
+		formatted, err := directive(name, spec, value[i], value[directiveStart:i+1])
+		if err != nil {
+			return "", err
+		}
+		expanded.WriteString(formatted)
+	}
+	return expanded.String(), nil
+}
+
+func formatExpansion(name string, replacement interface{}, spec string, conversion byte) (string, error) {
+	switch conversion {
+	case 's', 'r', 'a':
+		text := fmt.Sprint(replacement)
+		if conversion != 's' {
+			text = "'" + text + "'"
+		}
+		return fmt.Sprintf("%"+spec+"s", text), nil
+	case 'd', 'i', 'u', 'o', 'x', 'X', 'c', 'e', 'E', 'f', 'F', 'g', 'G':
+		number, ok := replacement.(int)
+		if !ok {
+			return "", fmt.Errorf("%%(%s)%c requires a number, but %s is a string", name, conversion, name)
+		}
+		switch conversion {
+		case 'd', 'i', 'u':
+			return fmt.Sprintf("%"+spec+"d", number), nil
+		case 'o', 'x', 'X':
+			return fmt.Sprintf("%"+spec+string(conversion), number), nil
+		case 'c':
+			return fmt.Sprintf("%"+spec+"c", rune(number)), nil
+		default:
+			return fmt.Sprintf("%"+spec+string(conversion), float64(number)), nil
+		}
+	}
+	return "", fmt.Errorf("unsupported format character %q in %%(%s)%s%c", conversion, name, spec, conversion)
+}
+
+func undefinedNameError(name string, expansions map[string]interface{}) error {
+	var available []string
+	for known := range expansions {
+		if !strings.HasPrefix(known, "ENV_") {
+			available = append(available, known)
+		}
+	}
+	sort.Strings(available)
+
+	if strings.HasPrefix(name, "ENV_") {
+		return fmt.Errorf("%%(%s)s is not defined, environment variable %s is not set",
+			name, strings.TrimPrefix(name, "ENV_"))
+	}
+	if name == "here" {
+		return fmt.Errorf("%%(here)s is not defined in settings from SUPERVISOR_* environment variables, as they aren't in a file")
+	}
+	return fmt.Errorf("%%(%s)s is not defined, available names are %s and ENV_<variable>",
+		name, strings.Join(available, ", "))
+}
+
+// programExpansions are the names available to the given key, one of
+// programInstanceKeys, with %(here)s being relative to the file it was set in
+func (configFileSection ProgramConfigSection) programExpansions(key string, processNum int) map[string]interface{} {
+	expansions := baseExpansions(configFileSection.keyFile(key))
+	expansions["program_name"] = configFileSection.programName
+	expansions["group_name"] = configFileSection.groupName
+	expansions["process_num"] = processNum
+	expansions["numprocs"] = configFileSection.NumProcs
+	expansions["numprocs_start"] = configFileSection.NumProcsStart
+	return expansions
+}
+
+// Expand returns a copy of the program config with the per instance keys
+// expanded for the given process number and the command split into arguments.
+func (configFileSection ProgramConfigSection) Expand(processNum int) (ProgramConfigSection, ConfigErrors) {
+	var configErrors ConfigErrors
+	expanded := configFileSection
+
+	expandKey := func(key string, value *string) bool {
+		var result string
+		var err error
+		expansions := configFileSection.programExpansions(key, processNum)
+		if key == "environment" {
+			result, err = expandEnvironment(*value, expansions)
+		} else {
+			result, err = expandString(*value, expansions)
+		}
+		if err != nil {
+			configErrors = append(configErrors, ConfigErrors{{
+				Section: configFileSection.sectionName,
+				Key:     key,
+				Value:   *value,
+				Reason:  err.Error(),
+			}}.inFile(configFileSection.keyFile(key))...)
+			return false
+		}
+		*value = result
+		return true
+	}
+
+	commandLine := configFileSection.CommandLine
+	if expandKey("command", &commandLine) {
+		command, err := splitCommandLine(commandLine)
+		if err != nil {
+			configErrors = append(configErrors, ConfigErrors{{
+				Section: configFileSection.sectionName,
+				Key:     "command",
+				Value:   configFileSection.CommandLine,
+				Reason:  err.Error(),
+			}}.inFile(configFileSection.keyFile("command"))...)
+		}
+		expanded.Command = command
+	}
+	expandKey("process_name", &expanded.ProcessName)
+	expandKey("directory", &expanded.Directory)
+	expandKey("stdout_logfile", &expanded.StdoutLogfile)
+	expandKey("stderr_logfile", &expanded.StderrLogfile)
+	expandKey("environment", &expanded.Environment)
+	expandKey("env_file", &expanded.EnvFile)
+	expandKey("user", &expanded.User)
+	for _, probe := range []struct {
+		key    string
+		config *ProbeConfig
+	}{
+		{"readiness_probe", &expanded.Readiness},
+		{"liveness_probe", &expanded.Liveness},
+	} {
+		line := probe.config.Line
+		if expandKey(probe.key, &probe.config.Line) {
+			parsed, err := ParseProbe(probe.config.Line)
+			if err != nil {
+				configErrors = append(configErrors, ConfigErrors{{
+					Section: configFileSection.sectionName,
+					Key:     probe.key,
+					Value:   line,
+					Reason:  err.Error(),
+				}}.inFile(configFileSection.keyFile(probe.key))...)
+			}
+			probe.config.Probe = parsed
+		}
+	}
+
+	return expanded, configErrors
+}
+
+// Instances expands the program into the numprocs processes that supervisord
+// would run for it, numbered from numprocs_start.
+func (configFileSection ProgramConfigSection) Instances() ([]ProgramConfigSection, ConfigErrors) {
+	if configFileSection.NumProcs < 1 {
+		return nil, ConfigErrors{{
+			Section: configFileSection.sectionName,
+			Key:     "numprocs",
+			Value:   strconv.Itoa(configFileSection.NumProcs),
+			Reason:  "numprocs must be at least 1",
+		}}
+	}
+	if configFileSection.NumProcs > 1 && !strings.Contains(configFileSection.ProcessName, "%(process_num)") {
+		return nil, ConfigErrors{{
+			Section: configFileSection.sectionName,
+			Key:     "process_name",
+			Value:   configFileSection.ProcessName,
+			Reason:  "%(process_num) must be present within process_name when numprocs > 1",
+		}}
+	}
+
+	var instances []ProgramConfigSection
+	for processNum := configFileSection.NumProcsStart; processNum < configFileSection.NumProcsStart+configFileSection.NumProcs; processNum++ {
+		instance, configErrors := configFileSection.Expand(processNum)
+		if configErrors != nil {
+			// Every instance would report the same problems
+			return nil, configErrors
+		}
+		instances = append(instances, instance)
+	}
+	return instances, nil
+}
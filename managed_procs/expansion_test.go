@@ -0,0 +1,201 @@
+package managed_procs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var testExpansions = map[string]interface{}{
+	"program_name": "web",
+	"process_num":  7,
+	"here":         "/etc/supervisor",
+	"ENV_HOME":     "/root",
+}
+
+func TestExpandString(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"", ""},
+		{"no expansions", "no expansions"},
+		{"100%%", "100%"},
+		{"%%(program_name)s", "%(program_name)s"},
+		{"%(program_name)s", "web"},
+		{"%(here)s/bin/%(program_name)s", "/etc/supervisor/bin/web"},
+		{"%(ENV_HOME)s", "/root"},
+		{"%(process_num)d", "7"},
+		{"%(process_num)i", "7"},
+		{"%(process_num)02d", "07"},
+		{"%(process_num)03d", "007"},
+		{"%(process_num)-3d|", "7  |"},
+		{"%(process_num)+d", "+7"},
+		{"%(process_num)ld", "7"},
+		{"%(process_num)x", "7"},
+		{"%(process_num)s", "7"},
+		{"%(process_num).1f", "7.0"},
+		{"%(program_name)5s|", "  web|"},
+		{"%(program_name)-5s|", "web  |"},
+		{"%(program_name)r", "'web'"},
+	}
+	for _, test := range tests {
+		got, err := expandString(test.value, testExpansions)
+		if err != nil {
+			t.Errorf("expandString(%q) returned error %s", test.value, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("expandString(%q) = %q, want %q", test.value, got, test.want)
+		}
+	}
+}
+
+func TestExpandStringErrors(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"100%", "incomplete format, a single % must be written as %%"},
+		{"50% off", `bad format at "% off"`},
+		{"%s", `bad format at "%s"`},
+		{"%(program_name", "incomplete format key"},
+		{"%(program_name)", "missing conversion type"},
+		{"%(program_name)02", "missing conversion type"},
+		{"%(program_name)d", "%(program_name)d requires a number, but program_name is a string"},
+		{"%(program_name)z", "unsupported format character 'z'"},
+		{"%(nope)s", "%(nope)s is not defined, available names are here, process_num, program_name and ENV_<variable>"},
+		{"%(ENV_NOPE)s", "%(ENV_NOPE)s is not defined, environment variable NOPE is not set"},
+		{"%(FILE:/nonexistent/secret)s", "can't read secret"},
+	}
+	for _, test := range tests {
+		got, err := expandString(test.value, testExpansions)
+		if err == nil {
+			t.Errorf("expandString(%q) = %q, want error containing %q", test.value, got, test.want)
+			continue
+		}
+		if !strings.Contains(err.Error(), test.want) {
+			t.Errorf("expandString(%q) returned error %q, want it to contain %q", test.value, err, test.want)
+		}
+	}
+}
+
+func TestExpandStringFile(t *testing.T) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "password"), []byte("s3cr3t-value\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	expansions := map[string]interface{}{"here": dir}
+
+	got, err := expandString("--password=%(FILE:%(here)s/password)s", expansions)
+	if err != nil {
+		t.Fatalf("expandString returned error %s", err)
+	}
+	if want := "--password=s3cr3t-value"; got != want {
+		t.Errorf("expandString() = %q, want %q", got, want)
+	}
+	if redacted := redactSecrets(got); redacted != "--password="+REDACTED {
+		t.Errorf("redactSecrets(%q) = %q, want the secret redacted", got, redacted)
+	}
+}
+
+func TestFlattenString(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"plain", "plain"},
+		{"100%%", "100%%"},
+		{"%(here)s/%(program_name)s", "/etc/supervisor/web"},
+		{"%(program_name)s_%(process_num)02d", "web_%(process_num)02d"},
+		{"%(FILE:%(here)s/secret)s", "%(FILE:/etc/supervisor/secret)s"},
+	}
+	keep := map[string]bool{"process_num": true}
+	for _, test := range tests {
+		got, err := flattenString(test.value, testExpansions, keep)
+		if err != nil {
+			t.Errorf("flattenString(%q) returned error %s", test.value, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("flattenString(%q) = %q, want %q", test.value, got, test.want)
+		}
+	}
+
+	// What a name expands to is escaped, so that expanding it again is harmless
+	expansions := map[string]interface{}{"ENV_RATE": "100%"}
+	got, err := flattenString("rate=%(ENV_RATE)s", expansions, nil)
+	if err != nil {
+		t.Fatalf("flattenString returned error %s", err)
+	}
+	if want := "rate=100%%"; got != want {
+		t.Errorf("flattenString() = %q, want %q", got, want)
+	}
+}
+
+func TestBaseExpansions(t *testing.T) {
+	os.Setenv("SUPERVISORGO_TEST_VALUE", "x")
+	defer os.Unsetenv("SUPERVISORGO_TEST_VALUE")
+
+	expansions := baseExpansions("/etc/supervisor/conf.d/web.conf")
+	if here := expansions["here"]; here != "/etc/supervisor/conf.d" {
+		t.Errorf("here = %v, want /etc/supervisor/conf.d", here)
+	}
+	if value := expansions["ENV_SUPERVISORGO_TEST_VALUE"]; value != "x" {
+		t.Errorf("ENV_SUPERVISORGO_TEST_VALUE = %v, want x", value)
+	}
+	if _, ok := expansions["host_node_name"]; !ok {
+		t.Error("host_node_name is missing")
+	}
+
+	if here, ok := baseExpansions(ENVIRONMENT_CONFIG_SOURCE)["here"]; ok {
+		t.Errorf("here = %v for settings from the environment, want it undefined", here)
+	}
+}
+
+func TestExpandPerKeyHere(t *testing.T) {
+	programSection := GetDefaultProgramSection("web")
+	programSection.configFile = "/base/supervisord.conf"
+	programSection.keyFiles = map[string]string{"directory": "/override/local.conf"}
+	programSection.CommandLine = "%(here)s/bin/web --name %(program_name)s_%(process_num)d"
+	programSection.ProcessName = "%(program_name)s"
+	programSection.Directory = "%(here)s"
+
+	expanded, configErrors := programSection.Expand(3)
+	if configErrors != nil {
+		t.Fatalf("Expand returned errors %s", configErrors)
+	}
+	if want := []string{"/base/bin/web", "--name", "web_3"}; strings.Join(expanded.Command, " ") != strings.Join(want, " ") {
+		t.Errorf("Command = %q, want %q", expanded.Command, want)
+	}
+	if expanded.Directory != "/override" {
+		t.Errorf("Directory = %q, want /override", expanded.Directory)
+	}
+}
+
+func TestInstances(t *testing.T) {
+	programSection := GetDefaultProgramSection("worker")
+	programSection.CommandLine = "/bin/worker %(process_num)d"
+	programSection.ProcessName = "%(program_name)s_%(process_num)02d"
+	programSection.NumProcs = 3
+	programSection.NumProcsStart = 1
+
+	instances, configErrors := programSection.Instances()
+	if configErrors != nil {
+		t.Fatalf("Instances returned errors %s", configErrors)
+	}
+	var names []string
+	for _, instance := range instances {
+		names = append(names, instance.ProcessName+"="+strings.Join(instance.Command, " "))
+	}
+	if got, want := strings.Join(names, ","), "worker_01=/bin/worker 1,worker_02=/bin/worker 2,worker_03=/bin/worker 3"; got != want {
+		t.Errorf("Instances() = %s, want %s", got, want)
+	}
+
+	programSection.ProcessName = "%(program_name)s"
+	if _, configErrors := programSection.Instances(); len(configErrors) != 1 || configErrors[0].Key != "process_name" {
+		t.Errorf("Instances() without %%(process_num) in process_name returned %v, want a process_name error", configErrors)
+	}
+}
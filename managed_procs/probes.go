@@ -0,0 +1,151 @@
+package managed_procs
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os/exec"
+	"time"
+)
+
+// Check runs the probe once against the process started by command
+func (probe Probe) Check(timeout time.Duration, command *exec.Cmd) error {
+	switch probe.Type {
+	case PROBE_EXEC:
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		// Run it the way the program itself was run
+		cmd := exec.CommandContext(ctx, probe.Command[0], probe.Command[1:]...)
+		cmd.Env = command.Env
+		cmd.Dir = command.Dir
+		cmd.SysProcAttr = command.SysProcAttr
+		umaskLock.Lock()
+		err := cmd.Start()
+		umaskLock.Unlock()
+		if err == nil {
+			err = cmd.Wait()
+		}
+		if ctx.Err() == context.DeadlineExceeded {
+			return fmt.Errorf("timed out after %s", timeout)
+		}
+		return err
+	case PROBE_TCP:
+		conn, err := net.DialTimeout("tcp", fmt.Sprintf("localhost:%d", probe.Port), timeout)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	case PROBE_HTTP:
+		client := http.Client{Timeout: timeout}
+		response, err := client.Get(fmt.Sprintf("http://localhost:%d%s", probe.Port, probe.Path))
+		if err != nil {
+			return err
+		}
+		response.Body.Close()
+		if response.StatusCode < 200 || response.StatusCode >= 400 {
+			return fmt.Errorf("HTTP status %s", response.Status)
+		}
+	}
+	return nil
+}
+
+// startProbes runs the program's probes against the process started by
+// command until done is closed, which happens when the process exits. The
+// results are acted on in the monitor loop.
+func (program *Program) startProbes(command *exec.Cmd, done chan struct{}) {
+	if program.config.Readiness.Probe.Type != PROBE_NONE {
+		go program.runProbe(program.config.Readiness, command, done, program.readinessResult)
+	}
+	if program.config.Liveness.Probe.Type != PROBE_NONE {
+		go program.runProbe(program.config.Liveness, command, done, program.livenessResult)
+	}
+}
+
+func (program *Program) runProbe(probeConfig ProbeConfig, command *exec.Cmd, done chan struct{}, result func(error)) {
+	// Give the process time to get going before the first check
+	select {
+	case <-time.After(time.Duration(probeConfig.InitialDelay) * time.Second):
+	case <-done:
+		return
+	}
+	ticker := time.NewTicker(time.Duration(probeConfig.Interval) * time.Second)
+	defer ticker.Stop()
+	for {
+		err := probeConfig.Probe.Check(time.Duration(probeConfig.Timeout)*time.Second, command)
+		select {
+		case program.requests <- func() {
+			// The process may have exited and been started again while the
+			// result was waiting for the monitor loop, which makes it stale
+			if program.command == command {
+				result(err)
+			}
+		}:
+		case <-done:
+			return
+		}
+		select {
+		case <-ticker.C:
+		case <-done:
+			return
+		}
+	}
+}
+
+// readinessResult marks the process ready after its probe first succeeds, and
+// not ready again after readiness_probe_failures failures in a row.
+func (program *Program) readinessResult(err error) {
+	if program.programStatus != PROC_RUNNING {
+		return
+	}
+	if err == nil {
+		program.readinessFailures = 0
+		if !program.ready {
+			log.Printf("%s is ready\n", program.Name())
+			program.ready = true
+		}
+		return
+	}
+	program.readinessFailures++
+	if program.readinessFailures == program.config.Readiness.Failures {
+		if program.ready {
+			log.Printf("%s is no longer ready: %s\n", program.Name(), err)
+		} else {
+			log.Printf("%s is not ready yet: %s\n", program.Name(), err)
+		}
+		program.ready = false
+	}
+}
+
+// livenessResult restarts the process after liveness_probe_failures failures
+// in a row, by stopping it, which counts as a failed start (see
+// HandleStateChange). The first success is what counts the start as
+// successful. Once it can't be started again, e.g. on shutdown, the result is
+// ignored and stopping it is left to whatever stops it.
+func (program *Program) livenessResult(err error) {
+	if program.programStatus != PROC_RUNNING || program.livenessFailed || !program.startable {
+		return
+	}
+	if err == nil {
+		program.livenessFailures = 0
+		program.failedStarts = 0
+		return
+	}
+	program.livenessFailures++
+	log.Printf("%s failed its liveness probe (%d of %d): %s\n",
+		program.Name(), program.livenessFailures, program.config.Liveness.Failures, err)
+	if program.livenessFailures >= program.config.Liveness.Failures {
+		log.Printf("Restarting %s as it is no longer alive\n", program.Name())
+		program.livenessFailed = true
+		program.signalStop()
+	}
+}
+
+// resetProbes forgets the results for an earlier run of the process
+func (program *Program) resetProbes() {
+	program.ready = false
+	program.readinessFailures = 0
+	program.livenessFailures = 0
+	program.livenessFailed = false
+}
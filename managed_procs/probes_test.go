@@ -0,0 +1,32 @@
+package managed_procs
+
+import (
+	"os/exec"
+	"testing"
+)
+
+func TestRunProbeDropsStaleResults(t *testing.T) {
+	first := exec.Command("/bin/true")
+	program := Program{command: first, requests: make(chan func())}
+	probeConfig := ProbeConfig{Probe: Probe{Type: PROBE_NONE}, Interval: 1, Timeout: 1}
+
+	results := 0
+	done := make(chan struct{})
+	defer close(done)
+	go program.runProbe(probeConfig, first, done, func(err error) {
+		results++
+	})
+
+	// A result for the process that is still running is used
+	(<-program.requests)()
+	if results != 1 {
+		t.Fatalf("result was called %d times for the running process, want 1", results)
+	}
+
+	// One that arrives once the process has been started again is dropped
+	program.command = exec.Command("/bin/true")
+	(<-program.requests)()
+	if results != 1 {
+		t.Errorf("result was called for a process that has since been replaced")
+	}
+}
@@ -3,11 +3,11 @@ package managed_procs
 import (
 	"fmt"
 	"log"
+	"math/rand"
 	"os"
 	"os/exec"
-	"os/user"
 	"reflect"
-	"strconv"
+	"sort"
 	"strings"
 	"syscall"
 	"time"
@@ -28,23 +28,47 @@ const (
 
 type Program struct {
 	config                 ProgramConfigSection
+	superConfig            SuperConfigSection
 	programStatus          ProcStatus
 	exitStatus             string
-	startCount             int
-	stdout                 *os.File
-	stderr                 *os.File
-	channel                chan ProcStatus
+	failedStarts           int
+	nextStart              time.Time
+	dependencies           []*Program
+	dependents             []*Program
+	processExited          chan struct{}
+	waitingForDependencies bool
+	requests               chan func()
+	ready                  bool
+	readinessFailures      int
+	livenessFailures       int
+	livenessFailed         bool
+	channel                chan stateChange
 	commandPath            string
 	programStatusTimestamp time.Time
 	command                *exec.Cmd
 	startable              bool
+	stopRequested          bool
+	restartRequested       bool
 	exitCode               int
 }
 
+// stateChange is how a running process reports back to the monitor loop,
+// which is the only place the Program is updated. Once started the process
+// hands over its command, and the exit status comes with the state it exited
+// to.
+type stateChange struct {
+	status        ProcStatus
+	command       *exec.Cmd
+	processExited chan struct{}
+	exitStatus    string
+	exitCode      int
+}
+
 type RunningData struct {
 	programs   []*Program
 	allConfig  AllConfig
 	inShutDown bool
+	requests   chan func()
 }
 
 func stateToString(state ProcStatus) string {
@@ -67,51 +91,96 @@ func stateToString(state ProcStatus) string {
 	return "unknown"
 }
 
+// Name is how supervisorctl would refer to the process: group:process, or
+// just the process name when it is alone in a group of the same name.
+func (program *Program) Name() string {
+	if program.config.groupName == program.config.ProcessName {
+		return program.config.ProcessName
+	}
+	return program.config.groupName + ":" + program.config.ProcessName
+}
+
 func (program *Program) UpdateStatus(status ProcStatus) {
 	program.programStatus = status
 	program.programStatusTimestamp = time.Now()
 	log.Printf("Process '%s' changed state to '%s'\n",
-		program.config.ProcessName,
+		program.Name(),
 		stateToString(program.programStatus))
 }
 
 func (allConfig AllConfig) InitialiseProcesses() []*Program {
 	programs := []*Program{}
-	for _, programConfig := range allConfig.Programs {
-		aProgram := Program{
-			config:     programConfig,
-			exitStatus: "",
-			startCount: 0,
-			channel:    make(chan ProcStatus),
-			startable:  false,
-		}
-		aProgram.UpdateStatus(PROC_STOPPED)
-
-		if len(aProgram.config.Command) == 0 {
-			log.Printf("No command specified for %s\n", aProgram.config.ProcessName)
-			aProgram.UpdateStatus(PROC_FATAL)
-			continue
-		}
-
-		path, err := exec.LookPath(aProgram.config.Command[0])
-		if err != nil {
-			log.Printf("Could not find command: %s\n", err)
-			aProgram.UpdateStatus(PROC_FATAL)
-			continue
+	for _, programTemplate := range allConfig.Programs {
+		instances, _ := programTemplate.Instances()
+		for _, programConfig := range instances {
+			aProgram := Program{
+				config:       programConfig,
+				superConfig:  allConfig.SuperVisorD,
+				exitStatus:   "",
+				failedStarts: 0,
+				channel:      make(chan stateChange),
+				startable:    false,
+			}
+			aProgram.UpdateStatus(PROC_STOPPED)
+			// Programs that can't be run are kept, so that their dependents
+			// wait for them. They stay STOPPED until something tries to
+			// start them.
+			programs = append(programs, &aProgram)
 		}
-		aProgram.commandPath = path
-		aProgram.channel = make(chan ProcStatus)
-		programs = append(programs, &aProgram)
 	}
 	return programs
 }
 
+// findCommand looks up the path of the program's command, putting the program
+// in FATAL if it can't be found. It is only called when the program is about
+// to be started, so that one nothing starts doesn't count as failed.
+func (program *Program) findCommand() bool {
+	if program.commandPath != "" {
+		return true
+	}
+	if len(program.config.Command) == 0 {
+		program.exitStatus = "no command specified"
+	} else if path, err := exec.LookPath(program.config.Command[0]); err != nil {
+		program.exitStatus = fmt.Sprintf("could not find command: %s", err)
+	} else {
+		program.commandPath = path
+		return true
+	}
+	log.Printf("Can't start %s: %s\n", program.Name(), program.exitStatus)
+	program.UpdateStatus(PROC_FATAL)
+	return false
+}
+
 func (allConfig AllConfig) RunAllProcesses() {
 	runningData := RunningData{
 		programs:   allConfig.InitialiseProcesses(),
 		allConfig:  allConfig,
 		inShutDown: false,
+		requests:   make(chan func()),
 	}
+
+	// Groups are started in order of priority, lowest first, and the programs
+	// within a group in order of their own priority. Stopping everything goes
+	// the other way.
+	sort.SliceStable(runningData.programs, func(i, j int) bool {
+		a, b := runningData.programs[i].config, runningData.programs[j].config
+		aPriority, bPriority := allConfig.GroupPriority(a), allConfig.GroupPriority(b)
+		if aPriority != bPriority {
+			return aPriority < bPriority
+		}
+		if a.groupName != b.groupName {
+			return a.groupName < b.groupName
+		}
+		if a.Priority != b.Priority {
+			return a.Priority < b.Priority
+		}
+		return a.ProcessName < b.ProcessName
+	})
+	runningData.orderByDependencies()
+	for _, program := range runningData.programs {
+		program.requests = runningData.requests
+	}
+
 	for _, prog := range runningData.programs {
 		if prog.config.AutoStart {
 			prog.startable = true
@@ -123,15 +192,25 @@ func (allConfig AllConfig) RunAllProcesses() {
 }
 
 func (runningData *RunningData) MonitorRunningProcesses() {
-	var chans []chan ProcStatus
+	var chans []chan stateChange
 	for _, program := range runningData.programs {
 		chans = append(chans, program.channel)
 	}
-	cases := make([]reflect.SelectCase, len(chans))
+	cases := make([]reflect.SelectCase, len(chans)+1)
 	for i, ch := range chans {
 		cases[i] = reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ch)}
 	}
+	// The last case handles requests such as starting or stopping a group
+	cases[len(chans)] = reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(runningData.requests)}
 	for {
+		// Wake up when the next process in BACKOFF is due to be started again
+		selectCases := cases
+		if nextStart := runningData.nextStart(); !nextStart.IsZero() {
+			timer := time.After(time.Until(nextStart))
+			selectCases = append(cases[:len(cases):len(cases)],
+				reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(timer)})
+		}
+
 		potentially_runable_processes := false
 		for _, program := range runningData.programs {
 			switch program.programStatus {
@@ -147,24 +226,23 @@ func (runningData *RunningData) MonitorRunningProcesses() {
 		if potentially_runable_processes {
 			// We will wait at this Select until one of our child processes changes state
 			// and notifies us...
-			chosen, value, ok := reflect.Select(cases)
-			if ok {
+			chosen, value, ok := reflect.Select(selectCases)
+			if ok && chosen == len(chans) {
+				value.Interface().(func())()
+				runningData.startWaitingProcesses()
+			} else if ok && chosen > len(chans) {
+				runningData.startDueProcesses()
+			} else if ok {
 				ch := chans[chosen]
-				state := value.Interface().(ProcStatus)
+				change := value.Interface().(stateChange)
 				// Find the program that uses this channel, then act.
 				for _, program := range runningData.programs {
 					if program.channel == ch {
-						program.UpdateStatus(state)
-						if state == PROC_RUNNING {
-							program.SetPriority()
-						} else {
-							// If we are supposed to start it again then do so
-							program.StartRunableProcess()
-						}
-
+						program.HandleStateChange(change)
 						break
 					}
 				}
+				runningData.startWaitingProcesses()
 			} else {
 				potentially_runable_processes = false
 			}
@@ -175,69 +253,294 @@ func (runningData *RunningData) MonitorRunningProcesses() {
 				syscall.Exit(3)
 			}
 			log.Println("Nothing to do, waiting...")
-			time.Sleep(5 * time.Second)
+			select {
+			case request := <-runningData.requests:
+				request()
+			case <-time.After(5 * time.Second):
+			}
+		}
+
+	}
+}
+
+// nextStart is the earliest time a process in BACKOFF is due to be started
+// again, or zero if none are.
+func (runningData *RunningData) nextStart() time.Time {
+	var earliest time.Time
+	for _, program := range runningData.programs {
+		if program.programStatus != PROC_BACKOFF || program.nextStart.IsZero() {
+			continue
+		}
+		if earliest.IsZero() || program.nextStart.Before(earliest) {
+			earliest = program.nextStart
+		}
+	}
+	return earliest
+}
+
+func (runningData *RunningData) startDueProcesses() {
+	now := time.Now()
+	for _, program := range runningData.programs {
+		if program.programStatus != PROC_BACKOFF || program.nextStart.IsZero() || program.nextStart.After(now) {
+			continue
+		}
+		program.nextStart = time.Time{}
+		log.Printf("Restarting %s\n", program.Name())
+		program.startProcess()
+	}
+}
+
+// startProcess runs the process in the background, it reports back through
+// its channel
+func (program *Program) startProcess() {
+	program.command, program.processExited = nil, nil
+	program.UpdateStatus(PROC_STARTING)
+	go program.RunSingleProcess()
+}
+
+func (program *Program) HandleStateChange(change stateChange) {
+	state := change.status
+	if state == PROC_STARTING {
+		// The process has been started and can be stopped from now on
+		program.command, program.processExited = change.command, change.processExited
+		if program.stopRequested {
+			// It was asked to stop before it got this far
+			program.signalStop()
+		}
+		return
+	}
+	if state != PROC_RUNNING {
+		program.exitStatus, program.exitCode = change.exitStatus, change.exitCode
+	}
+
+	if program.stopRequested {
+		if state == PROC_RUNNING {
+			// It has already been sent its stopsignal
+			return
+		}
+		program.stopRequested = false
+		program.UpdateStatus(PROC_STOPPED)
+		if program.restartRequested {
+			program.restartRequested = false
+			program.Start()
+		}
+		return
+	}
+
+	if state == PROC_EXITED && program.livenessFailed {
+		// Failing the liveness probe is a failed start, so that it is retried
+		// after the backoff delay and goes FATAL after startretries
+		state = PROC_BACKOFF
+	}
+	program.UpdateStatus(state)
+	if state == PROC_RUNNING {
+		// With a liveness probe the start only counts once it succeeds
+		if program.config.Liveness.Probe.Type == PROBE_NONE {
+			program.failedStarts = 0
+		}
+		program.resetProbes()
+	} else {
+		// If we are supposed to start it again then do so
+		program.StartRunableProcess()
+	}
+}
+
+func (program *Program) Start() {
+	program.startable = true
+	program.restartRequested = false
+	switch program.programStatus {
+	case PROC_EXITED, PROC_FATAL:
+		program.programStatus = PROC_STOPPED
+		fallthrough
+	case PROC_STOPPED:
+		program.failedStarts = 0
+		program.StartRunableProcess()
+	}
+}
+
+func (program *Program) Stop() {
+	program.startable = false
+	program.restartRequested = false
+	program.nextStart = time.Time{}
+	program.waitingForDependencies = false
+	switch program.programStatus {
+	case PROC_STARTING, PROC_RUNNING:
+		log.Printf("Stopping %s\n", program.Name())
+		program.stopRequested = true
+		program.UpdateStatus(PROC_STOPPING)
+		program.signalStop()
+	case PROC_BACKOFF, PROC_EXITED, PROC_FATAL:
+		program.UpdateStatus(PROC_STOPPED)
+	}
+}
+
+func (program *Program) Restart() {
+	switch program.programStatus {
+	case PROC_STARTING, PROC_RUNNING:
+		program.Stop()
+		program.restartRequested = true
+	case PROC_STOPPING:
+		program.restartRequested = true
+	default:
+		program.Start()
+	}
+}
+
+// FindProcesses returns the processes a supervisorctl style name refers to:
+// group:process, group:* or group: for a whole group, or on its own the name
+// of either a process or a group.
+func (runningData *RunningData) FindProcesses(name string) []*Program {
+	var found []*Program
+	groupName, processName := name, "*"
+	if parts := strings.SplitN(name, ":", 2); len(parts) == 2 {
+		groupName, processName = parts[0], parts[1]
+		if processName == "" {
+			processName = "*"
 		}
+	}
 
+	for _, program := range runningData.programs {
+		if program.config.groupName == groupName &&
+			(processName == "*" || processName == program.config.ProcessName) {
+			found = append(found, program)
+		} else if !strings.Contains(name, ":") && program.config.ProcessName == name {
+			found = append(found, program)
+		}
 	}
+	return found
+}
+
+// do runs fn in the monitor loop, so that it doesn't race with it
+func (runningData *RunningData) do(fn func()) {
+	done := make(chan bool)
+	runningData.requests <- func() {
+		fn()
+		close(done)
+	}
+	<-done
+}
+
+func (runningData *RunningData) StartGroup(name string) error {
+	programs := runningData.FindProcesses(name)
+	if len(programs) == 0 {
+		return fmt.Errorf("no such process or group: %s", name)
+	}
+	runningData.do(func() {
+		for _, program := range programs {
+			program.Start()
+		}
+	})
+	return nil
+}
+
+func (runningData *RunningData) StopGroup(name string) error {
+	programs := runningData.FindProcesses(name)
+	if len(programs) == 0 {
+		return fmt.Errorf("no such process or group: %s", name)
+	}
+	runningData.do(func() {
+		// Stop in the opposite order to starting
+		for i := len(programs) - 1; i >= 0; i-- {
+			programs[i].Stop()
+		}
+	})
+	return nil
+}
+
+func (runningData *RunningData) RestartGroup(name string) error {
+	programs := runningData.FindProcesses(name)
+	if len(programs) == 0 {
+		return fmt.Errorf("no such process or group: %s", name)
+	}
+	runningData.do(func() {
+		for _, program := range programs {
+			program.Restart()
+		}
+	})
+	return nil
 }
 
 func (prog *Program) StartRunableProcess() {
 	switch prog.programStatus {
 	case PROC_STOPPED:
-		if prog.startable {
-			log.Printf("Starting %s\n", prog.config.ProcessName)
-			prog.UpdateStatus(PROC_STARTING)
-			prog.startCount++
-			go prog.RunSingleProcess()
+		if prog.startable && prog.findCommand() && !prog.waitForDependencies() {
+			log.Printf("Starting %s\n", prog.Name())
+			prog.startProcess()
 		}
 	case PROC_BACKOFF:
-		prog.startCount++
+		prog.failedStarts++
 		prog.TryRestart()
 	case PROC_EXITED:
-		prog.startCount = 0
+		prog.failedStarts = 0
 		prog.TryRestart()
 	}
 }
 
 func (prog *Program) TryRestart() {
-	if prog.CanRestart() {
-		log.Printf("Restarting %s\n", prog.config.ProcessName)
-		prog.UpdateStatus(PROC_STARTING)
-		go prog.RunSingleProcess()
+	if !prog.startable {
+		log.Printf("%s is %s, not restarting while shutting down\n", prog.Name(), stateToString(prog.programStatus))
+		return
+	}
+	canRestart := prog.CanRestart()
+	if canRestart && prog.programStatus == PROC_BACKOFF {
+		// The monitor loop starts it again once the delay is up
+		delay := prog.backoffDelay()
+		prog.nextStart = time.Now().Add(delay)
+		log.Printf("Retrying %s in %s\n", prog.Name(), delay)
+	} else if canRestart {
+		log.Printf("Restarting %s\n", prog.Name())
+		prog.startProcess()
 	} else if prog.programStatus == PROC_STOPPED || prog.programStatus == PROC_EXITED {
-		log.Printf("%s is %s, not restarting\n", prog.config.ProcessName, stateToString(prog.programStatus))
+		log.Printf("%s is %s, not restarting\n", prog.Name(), stateToString(prog.programStatus))
 	} else {
 		prog.UpdateStatus(PROC_FATAL)
-		log.Printf("Process '%s' will not restart automatically\n", prog.config.ProcessName)
+		log.Printf("Process '%s' will not restart automatically\n", prog.Name())
 	}
 }
 
-func (program *Program) CanRestart() bool {
-	if program.programStatus == PROC_BACKOFF && program.startCount > program.config.StartRetries {
-		return false
+// backoffDelay is how long to wait before starting the program again after a
+// failed start. As in supervisord it grows by backoff_initial with each
+// failure (1s, 2s, 3s... by default), here up to backoff_max if that is set,
+// and then up to backoff_jitter is added at random so that programs failing
+// together don't all retry together.
+func (program *Program) backoffDelay() time.Duration {
+	delay := time.Duration(program.config.BackoffInitial*program.failedStarts) * time.Second
+	if maxDelay := time.Duration(program.config.BackoffMax) * time.Second; maxDelay > 0 && delay > maxDelay {
+		delay = maxDelay
 	}
-
-	if program.config.AutoRestart == "true" {
-		return true
+	if program.config.BackoffJitter > 0 {
+		jitter := time.Duration(rand.Int63n(int64(time.Duration(program.config.BackoffJitter) * time.Second)))
+		delay += jitter.Round(time.Millisecond)
 	}
+	return delay
+}
 
-	if program.config.AutoRestart == "unexpected" {
-		exitStatus := program.exitStatus
-		log.Printf("Handling 'unexpected' exit, status: %s", exitStatus)
-		if strings.HasPrefix(exitStatus, "exit status ") {
-			exitStatus = program.exitStatus[12:]
+// CanRestart follows supervisord: a start that failed is retried up to
+// startretries times whatever autorestart= says, while a process that exited
+// after reaching RUNNING is restarted as autorestart= says. Being stopped for
+// failing the liveness probe counts as a failed start.
+func (program *Program) CanRestart() bool {
+	switch program.programStatus {
+	case PROC_BACKOFF:
+		if program.failedStarts > program.config.StartRetries {
+			log.Printf("%s failed to start %d times, giving up\n", program.Name(), program.failedStarts)
+			return false
 		}
-		expectedCodes := strings.Split(program.config.ExitCodes, ",")
-		for _, expectedCode := range expectedCodes {
-			if expectedCode == exitStatus {
-				return true
+		return true
+	case PROC_EXITED:
+		switch program.config.AutoRestart {
+		case AUTORESTART_TRUE:
+			return true
+		case AUTORESTART_UNEXPECTED:
+			if program.config.ExitCodes.Contains(program.exitCode) {
+				log.Printf("%s exited with expected status %s\n", program.Name(), program.exitStatus)
+				return false
 			}
+			log.Printf("%s exited with unexpected status %s, expecting one of %s\n",
+				program.Name(), program.exitStatus, program.config.ExitCodes)
+			return true
 		}
-
-		log.Printf("Unexpected error (%s)\n", program.exitStatus)
-		log.Printf("Expecting (%s)\n", program.config.ExitCodes)
 	}
-
 	return false
 }
 
@@ -247,31 +550,35 @@ func (program *Program) CreateCommand() *exec.Cmd {
 	if len(program.config.Command) > 1 {
 		args := []string{}
 		args = program.config.Command[1:]
-		log.Printf("Running %s %s\n", program.commandPath, args)
+		log.Printf("Running %s %s\n", program.commandPath, redactSecrets(fmt.Sprint(args)))
 		cmd = exec.Command(program.commandPath, args...)
 	} else {
 		log.Printf("Running %s\n", program.commandPath)
 		cmd = exec.Command(program.commandPath)
 	}
-	program.command = cmd
 	return cmd
 }
 
-func (program *Program) SetPriority() {
-	cmd := program.command
-	var err error
-	err = syscall.Setpriority(syscall.PRIO_PROCESS, cmd.Process.Pid, program.config.Priority)
+// SetNice sets the scheduling priority of the process to its nice=, unlike
+// priority= which only decides the order programs are started and stopped in.
+func (program *Program) SetNice(cmd *exec.Cmd) {
+	if program.config.Nice == 0 {
+		return
+	}
+	err := syscall.Setpriority(syscall.PRIO_PROCESS, cmd.Process.Pid, program.config.Nice)
 	if err == nil {
-		log.Printf("PRIORITY: Process %s priority set %d",
-			program.config.ProcessName, program.config.Priority)
-
+		log.Printf("NICE: Process %s nice set to %d", program.Name(), program.config.Nice)
 	} else {
-		log.Printf("PRIORITY: Could not set priority for process %s", program.config.ProcessName)
-		log.Println(err)
+		log.Printf("NICE: Could not set nice for process %s: %s", program.Name(), err)
 	}
 }
 
-func (program *Program) SetIO() {
+// SetIO connects the command to the program's log files, which the caller
+// closes once the process has exited
+func (program *Program) SetIO(cmd *exec.Cmd) (stdout *os.File, stderr *os.File) {
+	umaskLock.Lock()
+	defer umaskLock.Unlock()
+
 	// Connect stdout
 	if program.config.StdoutLogfile == "" || program.config.StdoutLogfile == "AUTO" {
 		program.config.StdoutLogfile = "/dev/stdout"
@@ -280,7 +587,6 @@ func (program *Program) SetIO() {
 	if stdouterr != nil {
 		log.Printf("Could not create %s", program.config.StdoutLogfile)
 	}
-	program.stdout = stdout
 
 	// Connect stderr
 	if program.config.StderrLogfile == "" || program.config.StderrLogfile == "AUTO" {
@@ -290,84 +596,168 @@ func (program *Program) SetIO() {
 	if stderrerr != nil {
 		log.Printf("Could not create %s", program.config.StdoutLogfile)
 	}
-	program.stderr = stderr
 
-	program.command.Stdout = program.stdout
-	program.command.Stderr = program.stderr
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	return stdout, stderr
 }
 
-func (program *Program) MaybeSwitchUser(cmd *exec.Cmd) error {
-	if program.config.User != "" {
-		user, err := user.Lookup(program.config.User)
-		if err != nil {
-			log.Printf("WARNING: User %s not found", program.config.User)
-			return err
+// MaybeSwitchUser has the command run as the program's user=, with the
+// groups the user is a member of, returning who it will run as.
+func (program *Program) MaybeSwitchUser(cmd *exec.Cmd) (*credential, error) {
+	if program.config.User == "" {
+		return nil, nil
+	}
+	cred, err := lookupCredential(program.config.User)
+	if err != nil {
+		return nil, err
+	}
+	switchNeeded, err := cred.needsSwitch()
+	if err != nil {
+		return nil, err
+	}
+	if switchNeeded {
+		log.Printf("Running '%s' as user '%s' (UID: %d, GID: %d, groups: %v)",
+			program.Name(), program.config.User, cred.uid, cred.gid, cred.groups)
+		cmd.SysProcAttr = &syscall.SysProcAttr{
+			Credential: &syscall.Credential{Uid: cred.uid, Gid: cred.gid, Groups: cred.groups},
 		}
+	}
+	return &cred, nil
+}
 
-		log.Printf("Attempting to run '%s' as user '%s'", program.config.ProcessName, program.config.User)
-		uid, erruid := strconv.ParseUint(user.Uid, 10, 32)
-		if erruid != nil {
-			log.Printf("Failed to convert %s to uid", user.Uid)
-			return erruid
-		}
-		gid, errgid := strconv.ParseUint(user.Gid, 10, 32)
-		if errgid != nil {
-			log.Printf("Failed to convert %s to gid", user.Gid)
-			return errgid
+// BuildEnvironment works out the environment the program is started with,
+// without touching the supervisor's own: the supervisor's environment, less
+// the SUPERVISOR_* variables it was configured with, then HOME, USER and
+// LOGNAME of its user= if it has one, then what the config sets (see
+// GetEnvarMap), then the SUPERVISOR_* variables supervisord sets for its
+// children.
+func (program *Program) BuildEnvironment(cred *credential) ([]string, error) {
+	envarmap := make(map[string]string)
+	for _, envar := range os.Environ() {
+		keyval := strings.SplitN(envar, "=", 2)
+		if len(keyval) == 2 && !isConfigVariable(keyval[0]) {
+			envarmap[keyval[0]] = keyval[1]
 		}
-		true_uid := uint32(uid)
-		true_gid := uint32(gid)
-		log.Printf("Using UID: %d, GID: %d", true_uid, true_gid)
+	}
+	if cred != nil {
+		envarmap["HOME"] = cred.user.HomeDir
+		envarmap["USER"] = cred.user.Username
+		envarmap["LOGNAME"] = cred.user.Username
+	}
 
-		cmd.SysProcAttr = &syscall.SysProcAttr{}
-		cmd.SysProcAttr.Credential = &syscall.Credential{Uid: true_uid, Gid: true_gid}
+	configured, err := program.config.GetEnvarMap(program.superConfig)
+	if err != nil {
+		return nil, err
+	}
+	for key, val := range configured {
+		envarmap[key] = val
 	}
-	return nil
-}
 
-func (program *Program) InjectEnvironmentVariables() {
-	if program.config.Environment != "" {
-		for key, val := range program.config.GetEnvarMap() {
-			os.Setenv(key, val)
-		}
+	envarmap["SUPERVISOR_ENABLED"] = "1"
+	envarmap["SUPERVISOR_PROCESS_NAME"] = program.config.ProcessName
+	envarmap["SUPERVISOR_GROUP_NAME"] = program.config.groupName
+
+	var keys []string
+	for key := range envarmap {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	environ := make([]string, 0, len(keys))
+	for _, key := range keys {
+		environ = append(environ, key+"="+envarmap[key])
 	}
+	return environ, nil
 }
 
-func (program *Program) RunSingleProcess() {
-	program.InjectEnvironmentVariables()
+// fatal gives up on the program for a reason retrying won't fix
+func (program *Program) fatal(reason string) {
+	log.Printf("Can't start %s: %s", program.Name(), reason)
+	program.channel <- stateChange{status: PROC_FATAL, exitStatus: reason}
+}
 
+func (program *Program) RunSingleProcess() {
 	cmd := program.CreateCommand()
-	program.MaybeSwitchUser(cmd)
+	cred, err := program.MaybeSwitchUser(cmd)
+	if err != nil {
+		program.fatal(err.Error())
+		return
+	}
+	if program.config.Directory != "" {
+		if err := checkDirectory(program.config.Directory); err != nil {
+			program.fatal(err.Error())
+			return
+		}
+		cmd.Dir = program.config.Directory
+	}
 
-	program.SetIO()
-	defer program.stdout.Close()
-	defer program.stderr.Close()
+	environ, err := program.BuildEnvironment(cred)
+	if err != nil {
+		log.Printf("Could not set up the environment of %s: %s", program.Name(), err)
+		program.channel <- stateChange{status: PROC_BACKOFF, exitStatus: err.Error()}
+		return
+	}
+	cmd.Env = environ
+
+	stdout, stderr := program.SetIO(cmd)
+	defer stdout.Close()
+	defer stderr.Close()
 
-	runerr := cmd.Start()
+	runerr := program.startCommand(cmd)
 	if runerr != nil {
-		program.channel <- PROC_BACKOFF
+		log.Printf("Could not start %s: %s", program.Name(), runerr)
+		program.channel <- stateChange{status: PROC_BACKOFF, exitStatus: runerr.Error()}
 		return
 	}
+	program.SetNice(cmd)
+	processExited := make(chan struct{})
+	program.channel <- stateChange{status: PROC_STARTING, command: cmd, processExited: processExited}
+
+	// It only counts as started once it has stayed up for startsecs
+	exited := make(chan error, 1)
+	go func() {
+		err := cmd.Wait()
+		close(processExited)
+		exited <- err
+	}()
+	var exitVal error
+	started := false
+	if program.config.StartSecs > 0 {
+		select {
+		case exitVal = <-exited:
+		case <-time.After(time.Duration(program.config.StartSecs) * time.Second):
+			started = true
+		}
+	} else {
+		started = true
+	}
+	if started {
+		program.channel <- stateChange{status: PROC_RUNNING}
+		done := make(chan struct{})
+		program.startProbes(cmd, done)
+		exitVal = <-exited
+		close(done)
+	}
 
-	program.channel <- PROC_RUNNING
-	exitVal := cmd.Wait()
-
+	exit := stateChange{exitStatus: "0", exitCode: 0}
 	if exitVal != nil {
-		program.exitStatus = fmt.Sprintf("%v", exitVal)
-		program.exitCode = 99
+		exit.exitStatus = fmt.Sprintf("%v", exitVal)
+		exit.exitCode = 99
 
 		exiterr, ok := exitVal.(*exec.ExitError)
 		if ok {
 			status, ok := exiterr.Sys().(syscall.WaitStatus)
 			if ok {
-				program.exitCode = status.ExitStatus()
+				exit.exitCode = status.ExitStatus()
 			}
 		}
+	}
 
-		program.channel <- PROC_BACKOFF
+	if started {
+		exit.status = PROC_EXITED
 	} else {
-		program.exitStatus = "0"
-		program.exitCode = 0
-		program.channel <- PROC_EXITED
+		log.Printf("%s exited too quickly (%s), within startsecs=%d", program.Name(), exit.exitStatus, program.config.StartSecs)
+		exit.status = PROC_BACKOFF
 	}
+	program.channel <- exit
 }
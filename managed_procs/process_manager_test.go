@@ -0,0 +1,41 @@
+package managed_procs
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoffDelay(t *testing.T) {
+	tests := []struct {
+		initial      int
+		max          int
+		failedStarts int
+		want         time.Duration
+	}{
+		{1, 0, 1, time.Second},
+		{1, 0, 3, 3 * time.Second},
+		{5, 0, 2, 10 * time.Second},
+		{1, 2, 5, 2 * time.Second},
+		{10, 15, 1, 10 * time.Second},
+		{10, 15, 2, 15 * time.Second},
+		{0, 0, 4, 0},
+	}
+	for _, test := range tests {
+		program := Program{failedStarts: test.failedStarts}
+		program.config.BackoffInitial = test.initial
+		program.config.BackoffMax = test.max
+		if got := program.backoffDelay(); got != test.want {
+			t.Errorf("backoffDelay() with backoff_initial=%d backoff_max=%d after %d failures = %s, want %s",
+				test.initial, test.max, test.failedStarts, got, test.want)
+		}
+	}
+
+	program := Program{failedStarts: 2}
+	program.config.BackoffInitial = 1
+	program.config.BackoffJitter = 3
+	for i := 0; i < 20; i++ {
+		if got := program.backoffDelay(); got < 2*time.Second || got > 5*time.Second {
+			t.Errorf("backoffDelay() with backoff_jitter=3 = %s, want from 2s to 5s", got)
+		}
+	}
+}
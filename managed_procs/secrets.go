@@ -0,0 +1,97 @@
+package managed_procs
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const REDACTED = "******"
+
+// Values from an env_file shorter than this are not redacted, they would
+// mostly match text that has nothing to do with them, e.g. DEBUG=1
+const MIN_REDACTED_LENGTH = 4
+
+// Where a secret was read from
+type secretSource struct {
+	path string
+	// Read with %(FILE:path)s rather than from an env_file
+	fileExpansion bool
+}
+
+// The values read with %(FILE:path)s or from an env_file, so that they can be
+// kept out of logs and config dumps.
+var secrets = struct {
+	sync.Mutex
+	sources map[string]secretSource
+}{sources: make(map[string]secretSource)}
+
+func addSecret(value string, source secretSource) {
+	if value == "" || (!source.fileExpansion && len(value) < MIN_REDACTED_LENGTH) {
+		return
+	}
+	secrets.Lock()
+	defer secrets.Unlock()
+	secrets.sources[value] = source
+}
+
+// readSecretFile reads the value for %(FILE:path)s, without the trailing
+// newline most editors and secret stores add.
+func readSecretFile(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("can't read secret: %s", err)
+	}
+	secret := strings.TrimSuffix(strings.TrimSuffix(string(data), "\n"), "\r")
+	addSecret(secret, secretSource{path: path, fileExpansion: true})
+	return secret, nil
+}
+
+func secretValues() map[string]secretSource {
+	secrets.Lock()
+	defer secrets.Unlock()
+	values := make(map[string]secretSource)
+	for secret, source := range secrets.sources {
+		values[secret] = source
+	}
+	return values
+}
+
+// sortedSecrets puts the longest first, so that a secret containing another
+// is replaced as a whole.
+func sortedSecrets(values map[string]secretSource) []string {
+	var sorted []string
+	for secret := range values {
+		sorted = append(sorted, secret)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if len(sorted[i]) != len(sorted[j]) {
+			return len(sorted[i]) > len(sorted[j])
+		}
+		return sorted[i] < sorted[j]
+	})
+	return sorted
+}
+
+// redactSecrets hides any secret in text that is about to be logged or printed
+func redactSecrets(text string) string {
+	values := secretValues()
+	for _, secret := range sortedSecrets(values) {
+		text = strings.Replace(text, secret, REDACTED, -1)
+	}
+	return text
+}
+
+// restoreSecretFiles puts the %(FILE:path)s back in place of the secrets read
+// with it, in a value that has already had its % signs escaped.
+func restoreSecretFiles(escaped string) string {
+	values := secretValues()
+	for _, secret := range sortedSecrets(values) {
+		if source := values[secret]; source.fileExpansion {
+			escaped = strings.Replace(escaped, escapeExpansion(secret), "%(FILE:"+source.path+")s", -1)
+		}
+	}
+	return escaped
+}
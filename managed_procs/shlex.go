@@ -0,0 +1,71 @@
+package managed_procs
+
+import (
+	"errors"
+	"strings"
+)
+
+// splitCommandLine splits a command into arguments the way python's
+// shlex.split does in POSIX mode, which is what supervisord uses:
+//
+//   - whitespace separates arguments
+//   - 'single quotes' keep everything up to the next single quote as is
+//   - "double quotes" do the same, except that \" and \\ are unescaped
+//   - outside of quotes a backslash escapes the next character
+//   - quoted and unquoted parts next to each other form one argument, so
+//     "a"b'c' is the single argument abc
+func splitCommandLine(commandLine string) ([]string, error) {
+	var args []string
+	var current strings.Builder
+	inArg := false
+
+	runes := []rune(commandLine)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case strings.ContainsRune(" \t\r\n", r):
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+		case r == '\\':
+			i++
+			if i >= len(runes) {
+				return nil, errors.New("no escaped character after trailing backslash")
+			}
+			current.WriteRune(runes[i])
+			inArg = true
+		case r == '\'':
+			end := i + 1
+			for end < len(runes) && runes[end] != '\'' {
+				end++
+			}
+			if end >= len(runes) {
+				return nil, errors.New("no closing single quotation")
+			}
+			current.WriteString(string(runes[i+1 : end]))
+			i = end
+			inArg = true
+		case r == '"':
+			i++
+			for ; i < len(runes) && runes[i] != '"'; i++ {
+				if runes[i] == '\\' && i+1 < len(runes) && (runes[i+1] == '"' || runes[i+1] == '\\') {
+					i++
+				}
+				current.WriteRune(runes[i])
+			}
+			if i >= len(runes) {
+				return nil, errors.New("no closing double quotation")
+			}
+			inArg = true
+		default:
+			current.WriteRune(r)
+			inArg = true
+		}
+	}
+	if inArg {
+		args = append(args, current.String())
+	}
+	return args, nil
+}
@@ -0,0 +1,63 @@
+package managed_procs
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitCommandLine(t *testing.T) {
+	tests := []struct {
+		commandLine string
+		want        []string
+	}{
+		{"", nil},
+		{"   ", nil},
+		{"/bin/true", []string{"/bin/true"}},
+		{"  java  -jar\tapp.jar\n", []string{"java", "-jar", "app.jar"}},
+		{"echo 'a b'  c", []string{"echo", "a b", "c"}},
+		{`echo 'a \" b'`, []string{"echo", `a \" b`}},
+		{`echo "a \" b"`, []string{"echo", `a " b`}},
+		{`echo "a \\ b"`, []string{"echo", `a \ b`}},
+		{`echo "a \n b"`, []string{"echo", `a \n b`}},
+		{`echo "it's"`, []string{"echo", "it's"}},
+		{`echo 'say "hi"'`, []string{"echo", `say "hi"`}},
+		{`echo a\ b`, []string{"echo", "a b"}},
+		{`echo \'a\'`, []string{"echo", "'a'"}},
+		{`echo "a"b'c'`, []string{"echo", "abc"}},
+		{`echo "" ''`, []string{"echo", "", ""}},
+		{`sh -c "exec \"$0\" --port=8080" /usr/bin/web`, []string{"sh", "-c", `exec "$0" --port=8080`, "/usr/bin/web"}},
+		{"echo héllo 'wörld'", []string{"echo", "héllo", "wörld"}},
+	}
+	for _, test := range tests {
+		got, err := splitCommandLine(test.commandLine)
+		if err != nil {
+			t.Errorf("splitCommandLine(%q) returned error %s", test.commandLine, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("splitCommandLine(%q) = %q, want %q", test.commandLine, got, test.want)
+		}
+	}
+}
+
+func TestSplitCommandLineErrors(t *testing.T) {
+	tests := []struct {
+		commandLine string
+		want        string
+	}{
+		{`echo a\`, "no escaped character after trailing backslash"},
+		{`echo 'a b`, "no closing single quotation"},
+		{`echo "a b`, "no closing double quotation"},
+		{`echo "a \"`, "no closing double quotation"},
+	}
+	for _, test := range tests {
+		got, err := splitCommandLine(test.commandLine)
+		if err == nil {
+			t.Errorf("splitCommandLine(%q) = %q, want error %q", test.commandLine, got, test.want)
+			continue
+		}
+		if err.Error() != test.want {
+			t.Errorf("splitCommandLine(%q) returned error %q, want %q", test.commandLine, err, test.want)
+		}
+	}
+}
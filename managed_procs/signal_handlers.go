@@ -1,59 +1,71 @@
 package managed_procs
 
 import (
+	"bytes"
+	"errors"
 	"os"
 	"os/signal"
 	"syscall"
 	"fmt"
 	"log"
 	"strings"
+	"time"
 )
 
+// KillAllProcessesAndDie stops every process that is still running the same
+// way a single one is stopped, in the opposite order to starting them. A
+// process isn't sent its stopsignal until those depending on it have stopped.
+// Processes that had already exited or failed are left as they are, so that
+// they still count against the exit code.
 func (runningData *RunningData) KillAllProcessesAndDie() {
-	var err error
-	var exitOK = true
-	runningData.inShutDown = true
-	for _, program := range runningData.programs {
-		status := program.programStatus
-		if status != PROC_FATAL && status != PROC_EXITED && status != PROC_STOPPED {
-			program.channel <- PROC_FATAL
-			switch program.config.StopSignal {
-			case "TERM":
-				log.Printf("Killing %s with SIGTERM", program.config.ProcessName)
-				err = program.command.Process.Signal(syscall.SIGTERM)
-			case "HUP":
-				log.Printf("Killing %s with SIGHUP", program.config.ProcessName)
-				err = program.command.Process.Signal(syscall.SIGHUP)
-			case "INT":
-				log.Printf("Killing %s with SIGINT", program.config.ProcessName)
-				err = program.command.Process.Signal(syscall.SIGINT)
-			case "QUIT":
-				log.Printf("Killing %s with SIGQUIT", program.config.ProcessName)
-				err = program.command.Process.Signal(syscall.SIGQUIT)
-			case "USR1":
-				log.Printf("Killing %s with SIGUSR1", program.config.ProcessName)
-				err = program.command.Process.Signal(syscall.SIGUSR1)
-			case "USR2":
-				log.Printf("Killing %s with SIGUSR2", program.config.ProcessName)
-				err = program.command.Process.Signal(syscall.SIGUSR2)
-			case "KILL":
-				log.Printf("Killing %s with SIGKILL", program.config.ProcessName)
-				err = program.command.Process.Kill()
-			default:
-				log.Printf("Killing %s with SIGKILL", program.config.ProcessName)
-				err = program.command.Process.Kill()
+	runningData.do(func() {
+		runningData.inShutDown = true
+		// Nothing is started again from here on
+		for _, program := range runningData.programs {
+			program.startable = false
+			program.nextStart = time.Time{}
+		}
+	})
+	for i := len(runningData.programs) - 1; i >= 0; i-- {
+		program := runningData.programs[i]
+		runningData.waitFor(func() bool {
+			for _, dependent := range program.dependents {
+				if !dependent.isStopped() {
+					return false
+				}
 			}
-			if err != nil &&
-						program.config.StopSignal != "KILL" &&
-						!strings.Contains(err.Error(), "process already finished") {
-				log.Printf("Tried to kill %s but got %s. Sending SIGKILL signal.", program.config.ProcessName, err)
-				program.command.Process.Signal(syscall.SIGKILL)
+			return true
+		})
+		runningData.do(func() {
+			if !program.isStopped() {
+				program.Stop()
 			}
-		}
-		log.Printf("%s exited with %d (%v)", program.config.ProcessName, program.exitCode, exitOK)
-		exitOK = exitOK && (program.exitCode == 0)
+		})
 	}
-	// Note: We might not get here due to the process manager killing us first
+	runningData.waitFor(func() bool {
+		for _, program := range runningData.programs {
+			if !program.isStopped() {
+				return false
+			}
+		}
+		return true
+	})
+
+	// Processes stopped here are fine, only those that had already exited with
+	// an error or failed count against the exit code
+	var exitOK = true
+	runningData.do(func() {
+		for _, program := range runningData.programs {
+			switch program.programStatus {
+			case PROC_FATAL:
+				log.Printf("%s is FATAL (%s)", program.Name(), program.exitStatus)
+				exitOK = false
+			case PROC_BACKOFF, PROC_EXITED:
+				log.Printf("%s is %s, exited with %d", program.Name(), stateToString(program.programStatus), program.exitCode)
+				exitOK = exitOK && (program.exitCode == 0)
+			}
+		}
+	})
 	if exitOK {
 		log.Println("Exiting with code 0")
 		syscall.Exit(0)
@@ -62,7 +74,21 @@ func (runningData *RunningData) KillAllProcessesAndDie() {
 	syscall.Exit(1)
 }
 
-func (runningData RunningData) SigTerm() {
+// waitFor polls until done, which is run in the monitor loop, returns true
+func (runningData *RunningData) waitFor(done func() bool) {
+	for {
+		var finished bool
+		runningData.do(func() {
+			finished = done()
+		})
+		if finished {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func (runningData *RunningData) SigTerm() {
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, syscall.SIGTERM)
 	log.Println("Capturing SIGTERM")
@@ -71,7 +97,7 @@ func (runningData RunningData) SigTerm() {
 	runningData.KillAllProcessesAndDie()
 }
 
-func (runningData RunningData) SigInt() {
+func (runningData *RunningData) SigInt() {
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, syscall.SIGINT)
 	log.Println("Capturing SIGINT")
@@ -80,7 +106,61 @@ func (runningData RunningData) SigInt() {
 	runningData.KillAllProcessesAndDie()
 }
 
-func (runningData RunningData) SignalHandlers() {
+// SigUsr1 logs the status of every process each time SIGUSR1 is received
+func (runningData *RunningData) SigUsr1() {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGUSR1)
+	log.Println("Capturing SIGUSR1")
+	for range c {
+		var status bytes.Buffer
+		runningData.do(func() {
+			runningData.PrintStatus(&status)
+		})
+		for _, line := range strings.Split(strings.TrimSuffix(status.String(), "\n"), "\n") {
+			log.Println(line)
+		}
+	}
+}
+
+func (runningData *RunningData) SignalHandlers() {
 	go runningData.SigTerm()
 	go runningData.SigInt()
-}
\ No newline at end of file
+	go runningData.SigUsr1()
+}
+
+// signalStop sends the process its stopsignal, then SIGKILL if it is still
+// running stopwaitsecs later.
+func (program *Program) signalStop() {
+	if program.command == nil || program.command.Process == nil {
+		return
+	}
+	process, exited := program.command.Process, program.processExited
+	log.Printf("Stopping %s with signal %s", program.Name(), signalName(program.config.StopSignal))
+	err := process.Signal(program.config.StopSignal)
+	if errors.Is(err, os.ErrProcessDone) || program.config.StopSignal == syscall.SIGKILL {
+		return
+	}
+	if err != nil {
+		log.Printf("Tried to stop %s but got %s. Sending SIGKILL signal.", program.Name(), err)
+		process.Signal(syscall.SIGKILL)
+		return
+	}
+
+	go func() {
+		select {
+		case <-exited:
+		case <-time.After(time.Duration(program.config.StopWaitSecs) * time.Second):
+			log.Printf("%s did not stop within stopwaitsecs=%d, sending SIGKILL", program.Name(), program.config.StopWaitSecs)
+			process.Signal(syscall.SIGKILL)
+		}
+	}()
+}
+
+// isStopped is whether the process has stopped, or never got going
+func (program *Program) isStopped() bool {
+	switch program.programStatus {
+	case PROC_STARTING, PROC_RUNNING, PROC_STOPPING:
+		return false
+	}
+	return true
+}
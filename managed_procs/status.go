@@ -0,0 +1,61 @@
+package managed_procs
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// PrintStatus writes a line per process in the style of supervisorctl status
+func (runningData *RunningData) PrintStatus(w io.Writer) {
+	for _, program := range runningData.programs {
+		fmt.Fprintf(w, "%-32s %-9s %s\n", program.Name(), stateToString(program.programStatus), program.statusDescription())
+	}
+}
+
+func (program *Program) statusDescription() string {
+	switch program.programStatus {
+	case PROC_RUNNING:
+		if program.command == nil || program.command.Process == nil {
+			return ""
+		}
+		description := fmt.Sprintf("pid %d, uptime %s", program.command.Process.Pid, formatUptime(time.Since(program.programStatusTimestamp)))
+		if program.config.Readiness.Probe.Type != PROBE_NONE {
+			if program.ready {
+				description += ", ready"
+			} else {
+				description += ", not ready"
+			}
+		}
+		return description
+	case PROC_BACKOFF:
+		reason := "exited too quickly"
+		if program.livenessFailed {
+			reason = "failed its liveness probe"
+		}
+		if program.nextStart.IsZero() {
+			return fmt.Sprintf("%s (%s)", reason, program.exitStatus)
+		}
+		return fmt.Sprintf("%s (%s), attempt %d of %d, next at %s (in %s)",
+			reason,
+			program.exitStatus,
+			program.failedStarts+1,
+			program.config.StartRetries+1,
+			program.nextStart.Format("15:04:05"),
+			time.Until(program.nextStart).Round(time.Second))
+	case PROC_EXITED, PROC_FATAL:
+		return program.exitStatus
+	case PROC_STOPPED:
+		if program.waitingForDependencies {
+			return fmt.Sprintf("waiting for %s", strings.Join(program.unmetDependencies(), ", "))
+		}
+	}
+	return ""
+}
+
+// formatUptime formats a duration as supervisorctl does, e.g. 1:02:03
+func formatUptime(uptime time.Duration) string {
+	seconds := int(uptime.Seconds())
+	return fmt.Sprintf("%d:%02d:%02d", seconds/3600, seconds/60%60, seconds%60)
+}
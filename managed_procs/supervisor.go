@@ -0,0 +1,84 @@
+package managed_procs
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"sync"
+	"syscall"
+)
+
+// The umask belongs to the whole supervisor rather than to a thread, so
+// programs are started one at a time while a program's own umask is in place,
+// and anything else that creates files or starts processes holds the lock too
+// so that it doesn't happen in the meantime.
+var umaskLock sync.Mutex
+
+// ApplySupervisorSettings sets up the supervisor itself as [supervisord]
+// says: its environment, umask and directory, all of which the programs
+// inherit, and then the user it runs as.
+func (allConfig AllConfig) ApplySupervisorSettings() error {
+	superConfig := allConfig.SuperVisorD
+
+	envarmap, err := superConfig.GetEnvarMap()
+	if err != nil {
+		return fmt.Errorf("can't set up the environment: %s", err)
+	}
+	for key, val := range envarmap {
+		os.Setenv(key, val)
+	}
+
+	if superConfig.Umask != "" {
+		umask, err := ParseUmask(superConfig.Umask)
+		if err != nil {
+			return err
+		}
+		syscall.Umask(umask)
+		log.Printf("Set umask to %03o\n", umask)
+	}
+
+	if superConfig.Directory != "" {
+		if err := os.Chdir(superConfig.Directory); err != nil {
+			return fmt.Errorf("can't change to directory: %s", err)
+		}
+		log.Printf("Changed directory to %s\n", superConfig.Directory)
+	}
+
+	if superConfig.User != "" {
+		if err := switchUser(superConfig.User); err != nil {
+			return err
+		}
+		log.Printf("Running as user %s\n", superConfig.User)
+	}
+	return nil
+}
+
+// checkDirectory makes sure a program can be started in the directory
+func checkDirectory(directory string) error {
+	info, err := os.Stat(directory)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("directory %s does not exist", directory)
+		}
+		return fmt.Errorf("can't use directory: %s", err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", directory)
+	}
+	return nil
+}
+
+// startCommand starts the program's command with its umask, if it has one
+func (program *Program) startCommand(cmd *exec.Cmd) error {
+	umaskLock.Lock()
+	defer umaskLock.Unlock()
+	if program.config.Umask != "" {
+		umask, err := ParseUmask(program.config.Umask)
+		if err != nil {
+			return err
+		}
+		defer syscall.Umask(syscall.Umask(umask))
+	}
+	return cmd.Start()
+}
@@ -0,0 +1,134 @@
+package managed_procs
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/go-ini/ini.v1"
+)
+
+// programSource is a section that contributes keys to a program, along with
+// the file it came from.
+type programSource struct {
+	section    *ini.Section
+	configFile string
+}
+
+// LoadProgramDefaults loads [program-defaults], whose keys replace the built
+// in defaults of every [program:x].
+func (allConfig *AllConfig) LoadProgramDefaults(iniSection *ini.Section, configFile string) ConfigErrors {
+	if len(allConfig.programDefaults) > 0 && !allConfig.override {
+		return ConfigErrors{allConfig.ignoreSection(configFile, iniSection.Name(), "section is duplicated, ignoring extra(s)")}
+	}
+	configErrors := allConfig.checkProgramSource(iniSection, configFile)
+	allConfig.programDefaults = append(allConfig.programDefaults, programSource{iniSection, configFile})
+	return configErrors
+}
+
+// LoadTemplate loads a [template:x] section, which programs and other
+// templates can use with extends=x.
+func (allConfig *AllConfig) LoadTemplate(iniSection *ini.Section, name string, configFile string) ConfigErrors {
+	if _, ok := allConfig.templates[name]; ok && !allConfig.override {
+		return ConfigErrors{allConfig.ignoreSection(configFile, iniSection.Name(), "section is duplicated, ignoring extra(s)")}
+	}
+	configErrors := allConfig.checkProgramSource(iniSection, configFile)
+	allConfig.templates[name] = append(allConfig.templates[name], programSource{iniSection, configFile})
+	return configErrors
+}
+
+// checkProgramSource expands the section and reports any problems with its
+// keys now, as the section is only applied to the programs once all the files
+// have been loaded.
+func (allConfig *AllConfig) checkProgramSource(iniSection *ini.Section, configFile string) ConfigErrors {
+	configErrors := expandSectionValues(iniSection, baseExpansions(configFile), programInstanceKeys)
+	scratch := GetDefaultProgramSection("")
+	return append(configErrors, scratch.LoadProgram(iniSection, "")...)
+}
+
+// ApplyTemplates builds every program up from its defaults: the built in
+// ones, then [program-defaults] (for [program:x] sections only, event
+// listeners would break if their output went elsewhere), then the templates
+// it extends, the most basic first, and finally its own sections.
+func (allConfig *AllConfig) ApplyTemplates() ConfigErrors {
+	var configErrors ConfigErrors
+	for _, name := range allConfig.programNames() {
+		programSection := allConfig.Programs[name]
+		var defaults []programSource
+		if strings.HasPrefix(programSection.sectionName, "program:") {
+			defaults = allConfig.programDefaults
+		}
+		own := allConfig.programSources[name]
+
+		// extends= may come from the program itself or from the defaults
+		extends := buildProgram(programSection, append(append([]programSource{}, defaults...), own...)).Extends
+		chain, err := allConfig.templateChain(extends)
+		if err != nil {
+			configErrors = append(configErrors, ConfigErrors{{
+				Section: programSection.sectionName,
+				Key:     "extends",
+				Value:   extends,
+				Reason:  err.Error(),
+			}}.inFile(programSection.configFile)...)
+		}
+
+		var sources []programSource
+		sources = append(sources, defaults...)
+		sources = append(sources, chain...)
+		sources = append(sources, own...)
+		programSection = buildProgram(programSection, sources)
+		programSection.Extends = extends
+		allConfig.Programs[name] = programSection
+
+		if eventListenerSection, ok := allConfig.EventListeners[name]; ok {
+			eventListenerSection.ProgramData = programSection
+			allConfig.EventListeners[name] = eventListenerSection
+		}
+	}
+	return configErrors
+}
+
+// buildProgram applies the sources in order to the built in defaults, noting
+// which file each key came from so that %(here)s is that file's directory.
+// Any problems with the keys have already been reported as they were loaded.
+func buildProgram(programSection ProgramConfigSection, sources []programSource) ProgramConfigSection {
+	built := GetDefaultProgramSection(programSection.programName)
+	built.sectionName = programSection.sectionName
+	built.groupName = programSection.groupName
+	built.configFile = programSection.configFile
+	built.keyFiles = make(map[string]string)
+	for _, source := range sources {
+		built.LoadProgram(source.section, programSection.programName)
+		for _, key := range source.section.KeyStrings() {
+			built.keyFiles[key] = source.configFile
+		}
+	}
+	return built
+}
+
+// templateChain returns the sections of the named template and of those it
+// extends in turn, the most basic first.
+func (allConfig *AllConfig) templateChain(name string) ([]programSource, error) {
+	var chain []programSource
+	var seen []string
+	for name != "" {
+		for _, seenName := range seen {
+			if seenName == name {
+				return nil, fmt.Errorf("template cycle: %s -> %s", strings.Join(seen, " -> "), name)
+			}
+		}
+		seen = append(seen, name)
+
+		sources, ok := allConfig.templates[name]
+		if !ok {
+			return nil, fmt.Errorf("no such template, expected a [template:%s] section", name)
+		}
+		chain = append(append([]programSource{}, sources...), chain...)
+
+		var template ProgramConfigSection
+		for _, source := range sources {
+			template.LoadProgram(source.section, name)
+		}
+		name = template.Extends
+	}
+	return chain, nil
+}
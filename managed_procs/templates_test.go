@@ -0,0 +1,151 @@
+package managed_procs
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func loadTestConfig(t *testing.T, contents string) (AllConfig, ConfigErrors) {
+	path := filepath.Join(t.TempDir(), "supervisord.conf")
+	if err := ioutil.WriteFile(path, []byte(contents), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return LoadAllConfig([]string{path}, false)
+}
+
+func TestApplyTemplates(t *testing.T) {
+	allConfig, configErrors := loadTestConfig(t, `
+[program-defaults]
+stdout_logfile=/dev/stdout
+startretries=5
+
+[template:base]
+stderr_logfile=/dev/stderr
+autorestart=unexpected
+priority=10
+
+[template:java]
+extends=base
+autorestart=true
+
+[program:collector]
+extends=java
+command=/bin/collector
+priority=20
+
+[program:plain]
+command=/bin/plain
+
+[eventlistener:listener]
+extends=base
+command=/bin/listener
+events=PROCESS_STATE
+`)
+	if configErrors.HasErrors() {
+		t.Fatalf("LoadAllConfig returned errors %s", configErrors)
+	}
+
+	collector := allConfig.Programs["collector"]
+	if collector.StdoutLogfile != "/dev/stdout" || collector.StartRetries != 5 {
+		t.Errorf("collector has stdout_logfile=%s startretries=%d, want the [program-defaults]", collector.StdoutLogfile, collector.StartRetries)
+	}
+	if collector.StderrLogfile != "/dev/stderr" {
+		t.Errorf("collector has stderr_logfile=%s, want /dev/stderr from [template:base]", collector.StderrLogfile)
+	}
+	if collector.AutoRestart != AUTORESTART_TRUE {
+		t.Errorf("collector has autorestart=%s, want true from [template:java] over [template:base]", collector.AutoRestart)
+	}
+	if collector.Priority != 20 || collector.Extends != "java" {
+		t.Errorf("collector has priority=%d extends=%s, want its own 20 and java", collector.Priority, collector.Extends)
+	}
+
+	plain := allConfig.Programs["plain"]
+	if plain.StdoutLogfile != "/dev/stdout" || plain.StderrLogfile == "/dev/stderr" {
+		t.Errorf("plain has stdout_logfile=%s stderr_logfile=%s, want only the [program-defaults]", plain.StdoutLogfile, plain.StderrLogfile)
+	}
+
+	listener := allConfig.EventListeners["listener"].ProgramData
+	if listener.StdoutLogfile == "/dev/stdout" {
+		t.Error("listener took stdout_logfile from [program-defaults], which only applies to programs")
+	}
+	if listener.AutoRestart != AUTORESTART_UNEXPECTED || listener.Priority != 10 {
+		t.Errorf("listener has autorestart=%s priority=%d, want [template:base]", listener.AutoRestart, listener.Priority)
+	}
+}
+
+func TestApplyTemplatesErrors(t *testing.T) {
+	tests := []struct {
+		config string
+		reason string
+	}{
+		{`
+[program:web]
+extends=missing
+command=/bin/web
+`, "no such template, expected a [template:missing] section"},
+		{`
+[template:self]
+extends=self
+
+[program:web]
+extends=self
+command=/bin/web
+`, "template cycle: self -> self"},
+		{`
+[template:a]
+extends=b
+
+[template:b]
+extends=c
+
+[template:c]
+extends=a
+
+[program:web]
+extends=a
+command=/bin/web
+`, "template cycle: a -> b -> c -> a"},
+	}
+	for _, test := range tests {
+		_, configErrors := loadTestConfig(t, test.config)
+		var errors ConfigErrors
+		for _, configError := range configErrors {
+			if !configError.Warning {
+				errors = append(errors, configError)
+			}
+		}
+		if len(errors) != 1 || errors[0].Key != "extends" || errors[0].Reason != test.reason {
+			t.Errorf("LoadAllConfig(%s) returned %s, want one extends error %q", strings.TrimSpace(test.config), errors, test.reason)
+		}
+	}
+}
+
+func TestTemplateChain(t *testing.T) {
+	allConfig, _ := loadTestConfig(t, `
+[template:base]
+priority=10
+
+[template:java]
+extends=base
+
+[template:app]
+extends=java
+`)
+	chain, err := allConfig.templateChain("app")
+	if err != nil {
+		t.Fatalf("templateChain(app) returned error %s", err)
+	}
+	var names []string
+	for _, source := range chain {
+		names = append(names, source.section.Name())
+	}
+	if got, want := strings.Join(names, ","), "template:base,template:java,template:app"; got != want {
+		t.Errorf("templateChain(app) = %s, want %s", got, want)
+	}
+
+	if chain, err := allConfig.templateChain(""); err != nil || len(chain) != 0 {
+		t.Errorf("templateChain(\"\") = %v, %v, want nothing", chain, err)
+	}
+}
@@ -0,0 +1,113 @@
+package managed_procs
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// credential is who a process runs as
+type credential struct {
+	user   *user.User
+	uid    uint32
+	gid    uint32
+	groups []uint32
+}
+
+// lookupCredential finds a user, by name or uid, along with the groups it is
+// a member of. The user can be followed by :group, by name or gid, to use
+// that group instead of the user's own.
+func lookupCredential(spec string) (credential, error) {
+	var cred credential
+	name, groupName := spec, ""
+	if parts := strings.SplitN(spec, ":", 2); len(parts) == 2 {
+		name, groupName = parts[0], parts[1]
+	}
+
+	found, err := user.Lookup(name)
+	if err != nil {
+		if _, numErr := strconv.ParseUint(name, 10, 32); numErr != nil {
+			return cred, fmt.Errorf("no such user %s", name)
+		}
+		if found, err = user.LookupId(name); err != nil {
+			return cred, fmt.Errorf("no such user %s", name)
+		}
+	}
+	cred.user = found
+
+	uid, err := strconv.ParseUint(found.Uid, 10, 32)
+	if err != nil {
+		return cred, fmt.Errorf("user %s has a uid that isn't a number: %s", name, found.Uid)
+	}
+	gidString := found.Gid
+	if groupName != "" {
+		group, err := user.LookupGroup(groupName)
+		if err != nil {
+			if _, numErr := strconv.ParseUint(groupName, 10, 32); numErr != nil {
+				return cred, fmt.Errorf("no such group %s", groupName)
+			}
+			if group, err = user.LookupGroupId(groupName); err != nil {
+				return cred, fmt.Errorf("no such group %s", groupName)
+			}
+		}
+		gidString = group.Gid
+	}
+	gid, err := strconv.ParseUint(gidString, 10, 32)
+	if err != nil {
+		return cred, fmt.Errorf("group of user %s has a gid that isn't a number: %s", name, gidString)
+	}
+	cred.uid, cred.gid = uint32(uid), uint32(gid)
+
+	groupIds, err := found.GroupIds()
+	if err != nil {
+		return cred, fmt.Errorf("can't look up the groups of user %s: %s", name, err)
+	}
+	for _, groupId := range groupIds {
+		if group, err := strconv.ParseUint(groupId, 10, 32); err == nil && uint32(group) != cred.gid {
+			cred.groups = append(cred.groups, uint32(group))
+		}
+	}
+	return cred, nil
+}
+
+// needsSwitch is whether running as the user means changing credentials, as
+// opposed to the supervisor already running as it. Only root can change them.
+func (cred credential) needsSwitch() (bool, error) {
+	if os.Getuid() == 0 {
+		return true, nil
+	}
+	if cred.uid == uint32(os.Getuid()) && cred.gid == uint32(os.Getgid()) {
+		return false, nil
+	}
+	return false, fmt.Errorf("can't switch to user %s, not running as root", cred.user.Username)
+}
+
+// switchUser makes the supervisor itself run as the user. Only root can do
+// that, though naming the user it already runs as is fine.
+func switchUser(name string) error {
+	cred, err := lookupCredential(name)
+	if err != nil {
+		return err
+	}
+	if switchNeeded, err := cred.needsSwitch(); !switchNeeded {
+		return err
+	}
+
+	groups := []int{int(cred.gid)}
+	for _, group := range cred.groups {
+		groups = append(groups, int(group))
+	}
+	if err := syscall.Setgroups(groups); err != nil {
+		return fmt.Errorf("can't set the groups of user %s: %s", name, err)
+	}
+	if err := syscall.Setgid(int(cred.gid)); err != nil {
+		return fmt.Errorf("can't switch to the group of user %s: %s", name, err)
+	}
+	if err := syscall.Setuid(int(cred.uid)); err != nil {
+		return fmt.Errorf("can't switch to user %s: %s", name, err)
+	}
+	return nil
+}